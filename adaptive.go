@@ -0,0 +1,87 @@
+package ratelimiter
+
+import "sync"
+
+// AdaptiveOptions holds the tunables of an AdaptiveLimiter. Rates are in
+// tokens per second, see RateLimiter.Limit
+//
+// # MaxLimit is the ceiling the rate recovers to, defaults to the limit of
+// the wrapped limiter
+//
+// # MinLimit is the floor the rate never drops below, defaults to 1% of
+// MaxLimit
+//
+// # IncreaseStep is added to the rate on every success, defaults to 10% of
+// MaxLimit
+//
+// # DecreaseFactor multiplies the rate on every failure (0.0 to 1.0),
+// defaults to 0.5
+type AdaptiveOptions struct {
+	MaxLimit       float64
+	MinLimit       float64
+	IncreaseStep   float64
+	DecreaseFactor float64
+}
+
+// AdaptiveLimiter adjusts the rate of a RateLimiter to the feedback of the
+// downstream service it protects, AIMD style: every reported failure, such as
+// a 429 response, cuts the rate by DecreaseFactor, and every success raises it
+// by IncreaseStep until it is back at MaxLimit. A run of failures therefore
+// backs off quickly, while sustained success recovers gradually.
+//
+// It embeds the RateLimiter, so it is used and waited on like one.
+type AdaptiveLimiter struct {
+	*RateLimiter
+
+	mu    sync.Mutex
+	opts  AdaptiveOptions
+	limit float64
+}
+
+func NewAdaptiveLimiter(rl *RateLimiter, opts AdaptiveOptions) *AdaptiveLimiter {
+	if opts.MaxLimit <= 0 {
+		opts.MaxLimit = rl.Limit()
+	}
+	if opts.MinLimit <= 0 {
+		opts.MinLimit = opts.MaxLimit / 100
+	}
+	opts.MinLimit = min(opts.MinLimit, opts.MaxLimit)
+	if opts.IncreaseStep <= 0 {
+		opts.IncreaseStep = opts.MaxLimit / 10
+	}
+	if opts.DecreaseFactor <= 0 || opts.DecreaseFactor >= 1 {
+		opts.DecreaseFactor = 0.5
+	}
+
+	limit := min(max(rl.Limit(), opts.MinLimit), opts.MaxLimit)
+	rl.SetLimit(limit)
+	return &AdaptiveLimiter{RateLimiter: rl, opts: opts, limit: limit}
+}
+
+// Report feeds the outcome of a call made under the limiter back into it,
+// lowering the rate on failure and raising it on success.
+func (al *AdaptiveLimiter) Report(success bool) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	limit := al.limit
+	if success {
+		limit = min(limit+al.opts.IncreaseStep, al.opts.MaxLimit)
+	} else {
+		limit = max(limit*al.opts.DecreaseFactor, al.opts.MinLimit)
+	}
+	if limit == al.limit {
+		return
+	}
+
+	al.limit = limit
+	al.RateLimiter.SetLimit(limit)
+}
+
+// Limit returns the rate the limiter has currently adapted to.
+func (al *AdaptiveLimiter) Limit() float64 {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	return al.limit
+}
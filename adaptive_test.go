@@ -0,0 +1,46 @@
+package ratelimiter
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveLimiter(t *testing.T) {
+	rl := newFakeLazyLimiter(Options{BurstAmount: 10, Interval: 100 * time.Millisecond}, newFakeClock())
+	al := NewAdaptiveLimiter(rl, AdaptiveOptions{})
+
+	// check compares the adapted rate with want, and with the rate the
+	// wrapped limiter actually refills at.
+	check := func(step string, want float64) {
+		t.Helper()
+
+		if got := al.Limit(); math.Abs(got-want) > 1e-9 {
+			t.Fatalf("%s: Limit = %v, want %v", step, got, want)
+		}
+		if got := al.RateLimiter.Limit(); math.Abs(got-want) > 1e-6 {
+			t.Fatalf("%s: wrapped limiter refills at %v/s, want %v/s", step, got, want)
+		}
+	}
+	check("start", 10)
+
+	// Failures halve the rate down to the floor of 1% of MaxLimit.
+	for _, want := range []float64{5, 2.5, 1.25} {
+		al.Report(false)
+		check("failure", want)
+	}
+	for range 10 {
+		al.Report(false)
+	}
+	check("many failures", 0.1)
+
+	// Successes add 10% of MaxLimit until it is reached again.
+	for _, want := range []float64{1.1, 2.1, 3.1} {
+		al.Report(true)
+		check("success", want)
+	}
+	for range 20 {
+		al.Report(true)
+	}
+	check("many successes", 10)
+}
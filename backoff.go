@@ -0,0 +1,69 @@
+package ratelimiter
+
+import (
+	"sync"
+	"time"
+)
+
+// Backoff paces retries against a RateLimiter: every denied Use doubles the
+// suggested wait, starting from the limiter's interval and capped at a
+// maximum, and a successful Use resets it.
+type Backoff struct {
+	rl       *RateLimiter
+	maxDelay time.Duration
+
+	mu     sync.Mutex
+	denied int
+}
+
+// NewBackoff returns a Backoff for rl whose waits never exceed maxDelay.
+// maxDelay <= 0 caps them at 64 times the interval.
+func NewBackoff(rl *RateLimiter, maxDelay time.Duration) *Backoff {
+	return &Backoff{rl: rl, maxDelay: maxDelay}
+}
+
+// Use is like RateLimiter.Use, and also grows or resets the backoff.
+func (b *Backoff) Use() bool {
+	ok := b.rl.Use()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ok {
+		b.denied = 0
+	} else {
+		b.denied++
+	}
+	return ok
+}
+
+// NextBackoff returns how long to wait before retrying after the denials
+// so far: zero after a successful Use, the interval after the first denial,
+// and twice as long after each further one, up to the cap.
+func (b *Backoff) NextBackoff() time.Duration {
+	b.mu.Lock()
+	denied := b.denied
+	b.mu.Unlock()
+
+	if denied == 0 {
+		return 0
+	}
+
+	delay := b.rl.Interval()
+	limit := b.maxDelay
+	if limit <= 0 {
+		limit = 64 * delay
+	}
+	for i := 1; i < denied && delay < limit; i++ {
+		delay *= 2
+	}
+	return min(delay, limit)
+}
+
+// Reset clears the denials, as a successful Use would.
+func (b *Backoff) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.denied = 0
+}
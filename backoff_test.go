@@ -0,0 +1,59 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	clk := newFakeClock()
+	rl := newFakeLazyLimiter(Options{BurstAmount: 1, Interval: time.Second}, clk)
+	b := NewBackoff(rl, 5*time.Second)
+
+	if !b.Use() {
+		t.Fatal("first Use failed")
+	}
+	if d := b.NextBackoff(); d != 0 {
+		t.Fatalf("NextBackoff after a successful Use = %v, want 0", d)
+	}
+
+	// Denials double the wait from the interval up to the cap.
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if b.Use() {
+			t.Fatal("Use of an exhausted limiter succeeded")
+		}
+		if d := b.NextBackoff(); d != want {
+			t.Fatalf("NextBackoff = %v, want %v", d, want)
+		}
+	}
+
+	clk.Advance(time.Second)
+	if !b.Use() {
+		t.Fatal("Use after a refill failed")
+	}
+	if d := b.NextBackoff(); d != 0 {
+		t.Fatalf("NextBackoff after a successful Use = %v, want it reset to 0", d)
+	}
+
+	b.Use()
+	if d := b.NextBackoff(); d != time.Second {
+		t.Fatalf("NextBackoff after a denial = %v, want 1s", d)
+	}
+	b.Reset()
+	if d := b.NextBackoff(); d != 0 {
+		t.Fatalf("NextBackoff after Reset = %v, want 0", d)
+	}
+}
+
+func TestBackoffDefaultCap(t *testing.T) {
+	rl := newFakeLazyLimiter(Options{BurstAmount: 1, Interval: time.Second}, newFakeClock())
+	rl.Drain()
+	b := NewBackoff(rl, 0)
+
+	for range 20 {
+		b.Use()
+	}
+	if d := b.NextBackoff(); d != 64*time.Second {
+		t.Fatalf("NextBackoff after 20 denials = %v, want the default cap of 64s", d)
+	}
+}
@@ -0,0 +1,54 @@
+package ratelimiter
+
+import "context"
+
+// ChildLimiter is a limiter nested in a parent one, e.g. for a tenant that
+// gets a limit of its own within a limit shared by all tenants. Every token
+// is taken from both the child's own bucket and the parent's, and only when
+// both can give one, so a child exceeds neither its own limit nor the
+// parent's.
+type ChildLimiter struct {
+	rl     *RateLimiter
+	parent *RateLimiter
+}
+
+// NewChildLimiter returns a child of parent with its own bucket configured
+// by opts, refilled until ctx is done like NewRateLimiterWithBurst.
+func NewChildLimiter(ctx context.Context, parent *RateLimiter, opts Options) *ChildLimiter {
+	return &ChildLimiter{rl: NewRateLimiterWithBurst(ctx, opts), parent: parent}
+}
+
+// Use consumes a token from the child and the parent if both have one
+// available, and consumes nothing otherwise. Both count the call as allowed
+// or denied, like their own Use.
+func (cl *ChildLimiter) Use() bool {
+	d, err := takeAll(lockOrder([]*RateLimiter{cl.rl, cl.parent}))
+	ok := d == 0 && err == nil
+	cl.rl.decided(ok, cl.rl.clock.Now())
+	cl.parent.decided(ok, cl.parent.clock.Now())
+	return ok
+}
+
+// Wait blocks until it can consume a token from both the child and the
+// parent, see WaitAll.
+func (cl *ChildLimiter) Wait(ctx context.Context) error {
+	return WaitAll(ctx, cl.rl, cl.parent)
+}
+
+// Refund gives n tokens back to both the child and the parent, see
+// RateLimiter.Refund.
+func (cl *ChildLimiter) Refund(n int) {
+	cl.rl.Refund(n)
+	cl.parent.Refund(n)
+}
+
+// Tokens returns the tokens the child could use, the lower of its own and
+// the parent's.
+func (cl *ChildLimiter) Tokens() float64 {
+	return min(cl.rl.Tokens(), cl.parent.Tokens())
+}
+
+// Close closes the child's own bucket, leaving the parent running.
+func (cl *ChildLimiter) Close() {
+	cl.rl.Close()
+}
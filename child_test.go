@@ -0,0 +1,41 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestChildLimiterUse(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	parent := newFakeLazyLimiter(Options{BurstAmount: 3, Interval: time.Hour}, clk)
+	a := &ChildLimiter{rl: newFakeLimiter(ctx, Options{BurstAmount: 2, Interval: time.Hour}, clk), parent: parent}
+	b := &ChildLimiter{rl: newFakeLimiter(ctx, Options{BurstAmount: 2, Interval: time.Hour}, clk), parent: parent}
+
+	var denied int
+	a.rl.SetOnDeny(func() { denied++ })
+
+	// a is capped by its own burst, b by what a left in the parent.
+	if !a.Use() || !a.Use() || a.Use() {
+		t.Fatal("want exactly two uses allowed by the child's own burst")
+	}
+	if !b.Use() || b.Use() {
+		t.Fatal("want exactly one use allowed by what is left in the parent")
+	}
+	if got := b.rl.CurrentBurst(); got != 1 {
+		t.Fatalf("child CurrentBurst after a use denied by the parent = %d, want 1", got)
+	}
+
+	if got := a.rl.Stats(); got.Allowed != 2 || got.Denied != 1 {
+		t.Fatalf("child Allowed, Denied = %d, %d, want 2, 1", got.Allowed, got.Denied)
+	}
+	if got := parent.Stats(); got.Allowed != 3 || got.Denied != 2 {
+		t.Fatalf("parent Allowed, Denied = %d, %d, want 3, 2", got.Allowed, got.Denied)
+	}
+	if denied != 1 {
+		t.Fatalf("OnDeny called %d times, want once", denied)
+	}
+}
@@ -0,0 +1,67 @@
+package ratelimiter
+
+import "time"
+
+// clock is the source of time for a RateLimiter. The real one is used
+// outside of tests; tests can construct limiters with a fake one that they
+// advance by hand instead of sleeping. Now must return times with a
+// monotonic reading, like time.Now does, as the limiter compares them to
+// each other and a wall clock step, e.g. by NTP, must not move its deadlines.
+type clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) ticker
+	NewTimer(d time.Duration) timer
+}
+
+type ticker interface {
+	C() <-chan time.Time
+	Reset(d time.Duration)
+	Stop()
+}
+
+type timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (realClock) NewTimer(d time.Duration) timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTicker) Reset(d time.Duration) {
+	t.t.Reset(d)
+}
+
+func (t realTicker) Stop() {
+	t.t.Stop()
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTimer) Stop() bool {
+	return t.t.Stop()
+}
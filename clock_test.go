@@ -0,0 +1,349 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only moves when Advance is called. Timers fire
+// as soon as time reaches them, and every tick of a ticker is handed to its
+// reader and waited on until the reader has reset or stopped the ticker,
+// which the refill goroutine does after every tick, so that a test sees the
+// refill done once Advance returns.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  []*fakeTimer
+	tickers []*fakeTicker
+}
+
+func newFakeClock() *fakeClock {
+	// A fixed wall time makes failures reproducible, and Add keeps the
+	// readings comparable to each other like a monotonic clock.
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTicker{clock: c, c: make(chan time.Time), next: c.now.Add(d), running: true}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1), at: c.now.Add(d), running: true}
+	c.timers = append(c.timers, t)
+	if d <= 0 {
+		c.fireLocked(t)
+	}
+	return t
+}
+
+// Advance moves the clock forward by d, firing every timer and tick that
+// falls due on the way in order.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	for {
+		timer, tick := c.nextLocked(end)
+		switch {
+		case timer != nil:
+			c.now = timer.at
+			c.fireLocked(timer)
+		case tick != nil:
+			c.now = tick.next
+			now, ack := c.now, make(chan struct{})
+			tick.ack = ack
+			c.mu.Unlock()
+
+			select {
+			case tick.c <- now:
+				// The reader may take a while to get the limiter's lock,
+				// but it must not take forever.
+				select {
+				case <-ack:
+				case <-time.After(time.Second):
+					panic("fakeClock: tick was never handled")
+				}
+			case <-time.After(100 * time.Millisecond):
+				// Nobody reads the ticker anymore, e.g. the limiter was
+				// closed, so real tickers would drop the tick too.
+				c.mu.Lock()
+				tick.running = false
+				c.mu.Unlock()
+			}
+			c.mu.Lock()
+		default:
+			c.now = end
+			c.mu.Unlock()
+			return
+		}
+	}
+}
+
+// nextLocked returns whichever running timer or ticker falls due first, no
+// later than end. The caller must hold c.mu.
+func (c *fakeClock) nextLocked(end time.Time) (*fakeTimer, *fakeTicker) {
+	var timer *fakeTimer
+	for _, t := range c.timers {
+		if t.running && !t.at.After(end) && (timer == nil || t.at.Before(timer.at)) {
+			timer = t
+		}
+	}
+	var tick *fakeTicker
+	for _, t := range c.tickers {
+		if t.running && !t.next.After(end) && (tick == nil || t.next.Before(tick.next)) {
+			tick = t
+		}
+	}
+	if timer != nil && (tick == nil || !tick.next.Before(timer.at)) {
+		return timer, nil
+	}
+	return nil, tick
+}
+
+// fireLocked sends the current time on the channel of t. The caller must
+// hold c.mu.
+func (c *fakeClock) fireLocked(t *fakeTimer) {
+	t.running = false
+	select {
+	case t.c <- c.now:
+	default:
+	}
+}
+
+type fakeTimer struct {
+	clock   *fakeClock
+	c       chan time.Time
+	at      time.Time
+	running bool
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	running := t.running
+	t.running = false
+	return running
+}
+
+type fakeTicker struct {
+	clock   *fakeClock
+	c       chan time.Time
+	next    time.Time
+	running bool
+	ack     chan struct{}
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Reset(d time.Duration) {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	t.next = t.clock.now.Add(d)
+	t.running = true
+	t.handled()
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	t.running = false
+	t.handled()
+}
+
+// handled tells Advance that the tick in flight, if any, was dealt with. The
+// caller must hold t.clock.mu.
+func (t *fakeTicker) handled() {
+	if t.ack != nil {
+		close(t.ack)
+		t.ack = nil
+	}
+}
+
+// newFakeLimiter returns a ticker-driven limiter on clk, refilled until ctx
+// is done like NewRateLimiterWithBurst.
+func newFakeLimiter(ctx context.Context, opts Options, clk *fakeClock) *RateLimiter {
+	rl := newRateLimiter(opts, clk)
+	rl.start(ctx)
+	return rl
+}
+
+// newFakeLazyLimiter is like NewLazyRateLimiter but on clk.
+func newFakeLazyLimiter(opts Options, clk *fakeClock) *RateLimiter {
+	rl := newRateLimiter(opts, clk)
+	rl.lazy = true
+	return rl
+}
+
+// eventually fails t unless cond becomes true within a second, for state
+// that goroutines woken by the clock update on their own.
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within a second")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// awaitTimer waits until a goroutine has a timer on clk running until at,
+// so that advancing the clock to at fires it.
+func awaitTimer(t *testing.T, clk *fakeClock, at time.Time) {
+	t.Helper()
+
+	eventually(t, func() bool {
+		clk.mu.Lock()
+		defer clk.mu.Unlock()
+
+		for _, timer := range clk.timers {
+			if timer.running && timer.at.Equal(at) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func TestFakeClockRefill(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	rl := newFakeLimiter(ctx, Options{BurstAmount: 3, Interval: time.Second}, clk)
+
+	for i := range 3 {
+		if !rl.Use() {
+			t.Fatalf("Use %d denied with a full bucket", i)
+		}
+	}
+	if rl.Use() {
+		t.Fatal("Use allowed with an empty bucket")
+	}
+
+	clk.Advance(999 * time.Millisecond)
+	if got := rl.CurrentBurst(); got != 0 {
+		t.Fatalf("CurrentBurst before the first tick = %d, want 0", got)
+	}
+
+	clk.Advance(time.Millisecond)
+	if got := rl.CurrentBurst(); got != 1 {
+		t.Fatalf("CurrentBurst after one tick = %d, want 1", got)
+	}
+
+	clk.Advance(5 * time.Second)
+	if got := rl.CurrentBurst(); got != 3 {
+		t.Fatalf("CurrentBurst after refilling = %d, want 3", got)
+	}
+}
+
+func TestFakeClockLazyRefill(t *testing.T) {
+	clk := newFakeClock()
+	rl := newFakeLazyLimiter(Options{BurstAmount: 2, Interval: time.Second}, clk)
+
+	rl.Drain()
+	clk.Advance(1500 * time.Millisecond)
+	if got := rl.Tokens(); got != 1.5 {
+		t.Fatalf("Tokens after 1.5 intervals = %g, want 1.5", got)
+	}
+	if !rl.Use() || rl.Use() {
+		t.Fatal("want exactly one Use allowed after 1.5 intervals")
+	}
+}
+
+func TestClockJumpForward(t *testing.T) {
+	for _, lazy := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		clk := newFakeClock()
+		opts := Options{BurstAmount: 2, Interval: time.Second, BurstInterval: 100 * time.Millisecond}
+		var rl *RateLimiter
+		if lazy {
+			rl = newFakeLazyLimiter(opts, clk)
+		} else {
+			rl = newFakeLimiter(ctx, opts, clk)
+		}
+		rl.Drain()
+
+		// A day passing at once refills no more than the burst.
+		clk.Advance(24 * time.Hour)
+		if got := rl.CurrentBurst(); got != 2 {
+			t.Fatalf("lazy=%v: CurrentBurst after a day = %d, want 2", lazy, got)
+		}
+		if !rl.Use() || rl.Use() {
+			t.Fatalf("lazy=%v: want the burst cooldown to hold after a day", lazy)
+		}
+		clk.Advance(100 * time.Millisecond)
+		if !rl.Use() || rl.Use() {
+			t.Fatalf("lazy=%v: want exactly the second token after the cooldown", lazy)
+		}
+		cancel()
+	}
+}
+
+func TestAllowAtWallClockTime(t *testing.T) {
+	rl := NewLazyRateLimiter(Options{BurstAmount: 1, Interval: time.Hour})
+	rl.Drain()
+
+	// Round strips the monotonic reading, as it is for times parsed or
+	// received from elsewhere.
+	now := time.Now().Round(0)
+	if rl.AllowAt(1, now.Add(30*time.Minute)) {
+		t.Fatal("AllowAt half an interval after draining = true, want false")
+	}
+	if !rl.AllowAt(1, now.Add(time.Hour+time.Minute)) {
+		t.Fatal("AllowAt an interval after draining = false, want true")
+	}
+}
+
+func TestFrozenClockDecisions(t *testing.T) {
+	clk := newFakeClock()
+	rl := newFakeLazyLimiter(Options{BurstAmount: 1, Interval: time.Second, BurstInterval: time.Second}, clk)
+
+	if !rl.Use() {
+		t.Fatal("Use denied with a full bucket")
+	}
+
+	// The next token and the end of the cooldown are due at the same
+	// instant, so with time frozen there every call agrees they are both
+	// there, and once the token is taken none finds another.
+	clk.Advance(time.Second)
+	if !rl.CanUse() || !rl.AllowAt(1, clk.Now()) {
+		t.Fatal("CanUse or AllowAt false at the instant the token is due")
+	}
+	if !rl.Use() {
+		t.Fatal("Use denied at the instant the token is due")
+	}
+	for i := range 3 {
+		if rl.CanUse() || rl.Use() {
+			t.Fatalf("call %d allowed with time frozen after the token was taken", i)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package ratelimiter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// statsJSON is the wire form of Stats served by DebugHandler, with
+// durations written as strings such as "1s" or "100ms".
+type statsJSON struct {
+	Allowed           uint64 `json:"allowed"`
+	Denied            uint64 `json:"denied"`
+	CurrentBurst      int    `json:"currentBurst"`
+	MaxBurst          int    `json:"maxBurst"`
+	Interval          string `json:"interval"`
+	WaitersBlocked    int    `json:"waitersBlocked"`
+	WaitCount         uint64 `json:"waitCount"`
+	TotalWaitDuration string `json:"totalWaitDuration"`
+}
+
+// DebugHandler returns a handler serving the limiter's Stats, as JSON by
+// default or in the Prometheus text format with ?format=prometheus, e.g. to
+// mount under /debug/ratelimiter on an internal admin mux. It only reads
+// the limiter, so it is safe to serve next to the traffic it limits, but
+// it is meant for operators and should not be exposed publicly.
+func (rl *RateLimiter) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats := rl.Stats()
+
+		if r.URL.Query().Get("format") == "prometheus" {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+			writeMetric(w, "ratelimiter_allowed_total", "counter", "Uses that were allowed.", stats.Allowed)
+			writeMetric(w, "ratelimiter_denied_total", "counter", "Uses that were denied.", stats.Denied)
+			writeMetric(w, "ratelimiter_current_burst", "gauge", "Tokens currently available.", stats.CurrentBurst)
+			writeMetric(w, "ratelimiter_max_burst", "gauge", "Maximum number of tokens.", stats.MaxBurst)
+			writeMetric(w, "ratelimiter_interval_seconds", "gauge", "Time between refills.", stats.Interval.Seconds())
+			writeMetric(w, "ratelimiter_waiters_blocked", "gauge", "Wait calls currently blocked.", stats.WaitersBlocked)
+			writeMetric(w, "ratelimiter_waits_total", "counter", "Wait calls that had to block.", stats.WaitCount)
+			writeMetric(w, "ratelimiter_wait_seconds_total", "counter", "Time Wait calls spent blocked.", stats.TotalWaitDuration.Seconds())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(statsJSON{
+			Allowed:           stats.Allowed,
+			Denied:            stats.Denied,
+			CurrentBurst:      stats.CurrentBurst,
+			MaxBurst:          stats.MaxBurst,
+			Interval:          stats.Interval.String(),
+			WaitersBlocked:    stats.WaitersBlocked,
+			WaitCount:         stats.WaitCount,
+			TotalWaitDuration: stats.TotalWaitDuration.String(),
+		})
+	})
+}
+
+// writeMetric writes a single sample in the Prometheus text format.
+func writeMetric(w http.ResponseWriter, name, typ, help string, value any) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, typ, name, value)
+}
@@ -0,0 +1,71 @@
+package ratelimiter
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newDebugLimiter() *RateLimiter {
+	rl := newFakeLazyLimiter(Options{BurstAmount: 3, Interval: time.Second}, newFakeClock())
+	for range 4 {
+		rl.Use()
+	}
+	return rl
+}
+
+func TestDebugHandlerJSON(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newDebugLimiter().DebugHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/ratelimiter", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Content-Type = %q, want application/json", ct)
+	}
+	var got map[string]any
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"allowed":           3.0,
+		"denied":            1.0,
+		"currentBurst":      0.0,
+		"maxBurst":          3.0,
+		"interval":          "1s",
+		"waitersBlocked":    0.0,
+		"waitCount":         0.0,
+		"totalWaitDuration": "0s",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("DebugHandler served %v, want %v", got, want)
+	}
+}
+
+func TestDebugHandlerPrometheus(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newDebugLimiter().DebugHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/ratelimiter?format=prometheus", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("Content-Type = %q, want text/plain", ct)
+	}
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE ratelimiter_allowed_total counter",
+		"ratelimiter_allowed_total 3",
+		"ratelimiter_denied_total 1",
+		"# TYPE ratelimiter_current_burst gauge",
+		"ratelimiter_current_burst 0",
+		"ratelimiter_max_burst 3",
+		"ratelimiter_interval_seconds 1",
+		"ratelimiter_waiters_blocked 0",
+		"ratelimiter_waits_total 0",
+		"ratelimiter_wait_seconds_total 0",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Prometheus output lacks %q:\n%s", line, body)
+		}
+	}
+}
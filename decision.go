@@ -0,0 +1,31 @@
+package ratelimiter
+
+import "context"
+
+// Decision is the outcome of a rate limit check, as stored in a context by
+// ContextWithDecision
+//
+// # Allowed reports whether the request was let through
+//
+// # Remaining is the number of tokens left after the check
+type Decision struct {
+	Allowed   bool
+	Remaining int
+}
+
+type decisionKey struct{}
+
+// ContextWithDecision returns a copy of ctx carrying a rate limit decision,
+// so that handlers and loggers further down can read it with
+// DecisionFromContext. The middleware in this package does so for every
+// request it passes on.
+func ContextWithDecision(ctx context.Context, allowed bool, remaining int) context.Context {
+	return context.WithValue(ctx, decisionKey{}, Decision{Allowed: allowed, Remaining: remaining})
+}
+
+// DecisionFromContext returns the decision stored in ctx by
+// ContextWithDecision, and false if there is none.
+func DecisionFromContext(ctx context.Context) (Decision, bool) {
+	d, ok := ctx.Value(decisionKey{}).(Decision)
+	return d, ok
+}
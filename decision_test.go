@@ -0,0 +1,26 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+)
+
+func TestContextWithDecision(t *testing.T) {
+	if d, ok := DecisionFromContext(context.Background()); ok {
+		t.Fatalf("DecisionFromContext without a decision = %+v, true", d)
+	}
+
+	for _, want := range []Decision{{Allowed: true, Remaining: 4}, {Allowed: false, Remaining: 0}} {
+		ctx := ContextWithDecision(context.Background(), want.Allowed, want.Remaining)
+		if got, ok := DecisionFromContext(ctx); !ok || got != want {
+			t.Errorf("DecisionFromContext = %+v, %v, want %+v, true", got, ok, want)
+		}
+	}
+
+	// A later decision takes precedence over an earlier one.
+	ctx := ContextWithDecision(context.Background(), true, 3)
+	ctx = ContextWithDecision(ctx, false, 0)
+	if got, _ := DecisionFromContext(ctx); got != (Decision{}) {
+		t.Fatalf("DecisionFromContext after a second decision = %+v, want the second one", got)
+	}
+}
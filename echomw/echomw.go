@@ -0,0 +1,48 @@
+// Package echomw provides Echo middleware backed by a ratelimiter.RateLimiter.
+// It lives in its own module so that the core ratelimiter module does not
+// depend on Echo.
+package echomw
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/joohnes/ratelimiter"
+)
+
+// Middleware consumes a token from rl for every request. When none is
+// available it responds with 429 Too Many Requests and a Retry-After header
+// telling the client when the next token will be, without calling the next
+// handler.
+func Middleware(rl *ratelimiter.RateLimiter) echo.MiddlewareFunc {
+	return middleware(func(echo.Context) *ratelimiter.RateLimiter { return rl })
+}
+
+// KeyedMiddleware is like Middleware but limits every client separately, as
+// identified by key, e.g. echo.Context.RealIP.
+func KeyedMiddleware(kl *ratelimiter.KeyedRateLimiter[string], key func(echo.Context) string) echo.MiddlewareFunc {
+	return middleware(func(c echo.Context) *ratelimiter.RateLimiter { return kl.Limiter(key(c)) })
+}
+
+func middleware(limiter func(echo.Context) *ratelimiter.RateLimiter) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			rl := limiter(c)
+			if !rl.Use() {
+				c.Response().Header().Set("Retry-After", retryAfter(rl))
+				return echo.NewHTTPError(http.StatusTooManyRequests)
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// retryAfter returns the seconds until rl has a token again, rounded up and
+// never less than one so that clients always back off.
+func retryAfter(rl *ratelimiter.RateLimiter) string {
+	return strconv.Itoa(max(int(math.Ceil(rl.TimeToNext().Seconds())), 1))
+}
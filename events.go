@@ -0,0 +1,67 @@
+package ratelimiter
+
+import "time"
+
+// DefaultEventBuffer is the buffer size of the channel returned by Events.
+const DefaultEventBuffer = 64
+
+// Event describes the outcome of a single Use or UseN call
+//
+// # Time is when the call was decided
+//
+// # Allowed reports whether tokens were consumed
+//
+// # TokensAfter is the number of whole tokens left right after the call
+//
+// # Key is the key of the call for limiters obtained from a
+// KeyedRateLimiter, and nil otherwise
+type Event struct {
+	Time        time.Time
+	Allowed     bool
+	TokensAfter int
+	Key         any
+}
+
+// Events returns a channel that receives an Event for every Use or UseN
+// call from now on, e.g. to feed a live dashboard. It is like
+// EventsWithBuffer with a buffer of DefaultEventBuffer.
+func (rl *RateLimiter) Events() <-chan Event {
+	return rl.EventsWithBuffer(DefaultEventBuffer)
+}
+
+// EventsWithBuffer is like Events with a buffer of size events. Only the
+// first call creates the channel and decides its buffer; later ones return
+// the same channel. Events are dropped rather than delivered late while the
+// buffer is full, so a slow consumer never stalls Use. The channel is never
+// closed.
+func (rl *RateLimiter) EventsWithBuffer(size int) <-chan Event {
+	rl.lock()
+	defer rl.unlock()
+
+	if ch := rl.events.Load(); ch != nil {
+		return *ch
+	}
+
+	ch := make(chan Event, max(size, 0))
+	rl.events.Store(&ch)
+	return ch
+}
+
+// emit sends the event for a Use or UseN call made at now, if anyone asked
+// for events.
+func (rl *RateLimiter) emit(allowed bool, now time.Time) {
+	ch := rl.events.Load()
+	if ch == nil {
+		return
+	}
+
+	rl.lock()
+	rl.refill(now)
+	tokens := max(rl.whole(), 0)
+	rl.unlock()
+
+	select {
+	case *ch <- Event{Time: now, Allowed: allowed, TokensAfter: tokens, Key: rl.key}:
+	default:
+	}
+}
@@ -0,0 +1,67 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvents(t *testing.T) {
+	clk := newFakeClock()
+	rl := newFakeLazyLimiter(Options{BurstAmount: 3, Interval: time.Hour}, clk)
+	events := rl.Events()
+	if again := rl.Events(); again != events {
+		t.Fatal("second Events call returned a different channel")
+	}
+
+	start := clk.Now()
+	for range 4 {
+		rl.Use()
+		clk.Advance(time.Millisecond)
+	}
+	rl.UseN(2)
+
+	want := []Event{
+		{Time: start, Allowed: true, TokensAfter: 2},
+		{Time: start.Add(time.Millisecond), Allowed: true, TokensAfter: 1},
+		{Time: start.Add(2 * time.Millisecond), Allowed: true, TokensAfter: 0},
+		{Time: start.Add(3 * time.Millisecond), Allowed: false, TokensAfter: 0},
+		{Time: start.Add(4 * time.Millisecond), Allowed: false, TokensAfter: 0},
+	}
+	if got := len(events); got != len(want) {
+		t.Fatalf("got %d events for %d calls", got, len(want))
+	}
+	for i, w := range want {
+		if got := <-events; got != w {
+			t.Fatalf("event %d = %+v, want %+v", i, got, w)
+		}
+	}
+}
+
+func TestEventsSlowConsumer(t *testing.T) {
+	rl := newFakeLazyLimiter(Options{BurstAmount: 10, Interval: time.Hour}, newFakeClock())
+	events := rl.EventsWithBuffer(1)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 100 {
+			rl.Use()
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Use blocked on an unread event channel")
+	}
+
+	// The buffer kept the first event and the rest were dropped.
+	if got := len(events); got != 1 {
+		t.Fatalf("%d events buffered, want 1", got)
+	}
+	if e := <-events; !e.Allowed || e.TokensAfter != 9 {
+		t.Fatalf("buffered event = %+v, want the first Use", e)
+	}
+	if got := rl.Stats().Allowed; got != 10 {
+		t.Fatalf("Allowed = %d, want 10", got)
+	}
+}
@@ -0,0 +1,88 @@
+package ratelimiter
+
+import (
+	"math"
+	"time"
+)
+
+// The lock-free path lets take consume tokens without rl.mu in the common
+// case of a limiter with tokens to spare. Whenever rl.mu is released with
+// nothing but the tokens deciding whether UseN succeeds, lend moves the
+// whole tokens of the bucket to fastTokens, where take claims them with a
+// compare-and-swap. Whoever acquires rl.mu next moves what is left back, so
+// the code holding rl.mu sees the bucket as if the lock-free path did not
+// exist, and only has to go through lock and unlock.
+//
+// fastTokens holds the lent tokens in its low fastCountBits and a
+// generation, bumped by every lend, above them. Without it a take that
+// loaded the tokens of one lend could succeed against a later lend of the
+// same number of tokens, despite the cooldown it checked being outdated.
+const (
+	fastCountBits = 32
+	fastCountMask = 1<<fastCountBits - 1
+)
+
+// lock acquires rl.mu and reclaims the tokens lent to the lock-free path.
+func (rl *RateLimiter) lock() {
+	rl.mu.Lock()
+	rl.reclaim()
+}
+
+// unlock lends the tokens to the lock-free path if it may take them and
+// releases rl.mu.
+func (rl *RateLimiter) unlock() {
+	rl.lend()
+	rl.mu.Unlock()
+}
+
+// reclaim moves the tokens the lock-free path has not taken back into the
+// bucket, keeping the generation. The caller must hold rl.mu.
+func (rl *RateLimiter) reclaim() {
+	for {
+		w := rl.fastTokens.Load()
+		if w&fastCountMask == 0 {
+			return
+		}
+		if rl.fastTokens.CompareAndSwap(w, w&^fastCountMask) {
+			rl.burst += float64(w & fastCountMask)
+			return
+		}
+	}
+}
+
+// lend moves the whole tokens of the bucket to the lock-free path, unless a
+// take may have to do more than consume them: start the burst cooldown or
+// the idle ticker, or find nothing at all because the limiter is disabled,
+// paused or closed or the tokens go to queued waiters first. The caller must
+// hold rl.mu and have reclaimed the tokens lent before.
+func (rl *RateLimiter) lend() {
+	if rl.lockOnly || rl.disabled || rl.closed || rl.paused || rl.idle ||
+		rl.burstInterval > 0 || len(rl.queue) > 0 || rl.burst < 1 {
+		return
+	}
+
+	n := min(math.Floor(rl.burst), fastCountMask)
+	rl.burst -= n
+	// The cooldown must be in place before the tokens it guards.
+	rl.fastCooldown.Store(int64(rl.burstCooldown.Sub(rl.epoch)))
+	gen := rl.fastTokens.Load()>>fastCountBits + 1
+	rl.fastTokens.Store(gen<<fastCountBits | int64(n))
+}
+
+// takeFast consumes n tokens lent to the lock-free path if there are enough
+// of them and the burst cooldown, which a Reservation may have pushed back,
+// has elapsed at now. It reports false whenever it cannot tell whether
+// take would succeed, for take to find out under rl.mu.
+func (rl *RateLimiter) takeFast(n int, now time.Time) bool {
+	n = max(n, 1)
+	for {
+		w := rl.fastTokens.Load()
+		if w&fastCountMask < int64(n) || int64(now.Sub(rl.epoch)) < rl.fastCooldown.Load() {
+			return false
+		}
+		if rl.fastTokens.CompareAndSwap(w, w-int64(n)) {
+			rl.allowed.Add(1)
+			return true
+		}
+	}
+}
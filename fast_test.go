@@ -0,0 +1,84 @@
+package ratelimiter
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLockFreeConcurrentUse(t *testing.T) {
+	rl := newFakeLazyLimiter(Options{BurstAmount: 1000, Interval: time.Hour}, newFakeClock())
+
+	// Calls under rl.mu keep reclaiming and lending the tokens meanwhile.
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				rl.CurrentBurst()
+			}
+		}
+	}()
+
+	var used atomic.Int64
+	var users sync.WaitGroup
+	for range 8 {
+		users.Add(1)
+		go func() {
+			defer users.Done()
+			for range 500 {
+				if rl.Use() {
+					used.Add(1)
+				}
+			}
+		}()
+	}
+	users.Wait()
+	close(stop)
+	wg.Wait()
+
+	if got := used.Load(); got != 1000 {
+		t.Fatalf("%d of 4000 uses succeeded with 1000 tokens", got)
+	}
+	if stats := rl.Stats(); stats.Allowed != 1000 || stats.CurrentBurst != 0 {
+		t.Fatalf("Allowed %d, CurrentBurst %d after using 1000 tokens, want 1000, 0", stats.Allowed, stats.CurrentBurst)
+	}
+}
+
+func TestLockFreeMatchesMutex(t *testing.T) {
+	for _, lockOnly := range []bool{false, true} {
+		clk := newFakeClock()
+		rl := newFakeLazyLimiter(Options{BurstAmount: 1, Interval: time.Second}, clk)
+		rl.lockOnly = lockOnly
+
+		// The reservation pushes the cooldown back to when its token comes
+		// due, which a token given back does not get around.
+		rl.Use()
+		rl.Reserve()
+		rl.Refund(2)
+		if rl.Use() {
+			t.Fatalf("lockOnly %v: Use before the reserved token came due succeeded", lockOnly)
+		}
+		clk.Advance(time.Second)
+		if !rl.Use() {
+			t.Fatalf("lockOnly %v: Use after the reserved token came due failed", lockOnly)
+		}
+
+		// Pausing takes back what the lock-free path may take.
+		rl.Refund(1)
+		rl.Pause()
+		if rl.Use() {
+			t.Fatalf("lockOnly %v: Use while paused succeeded", lockOnly)
+		}
+		rl.Resume()
+		if !rl.Use() {
+			t.Fatalf("lockOnly %v: Use after Resume failed", lockOnly)
+		}
+	}
+}
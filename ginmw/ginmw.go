@@ -0,0 +1,46 @@
+// Package ginmw provides Gin middleware backed by a ratelimiter.RateLimiter.
+// It lives in its own module so that the core ratelimiter module does not
+// depend on Gin.
+package ginmw
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/joohnes/ratelimiter"
+)
+
+// Middleware consumes a token from rl for every request. When none is
+// available it aborts the request with 429 Too Many Requests and a
+// Retry-After header telling the client when the next token will be.
+func Middleware(rl *ratelimiter.RateLimiter) gin.HandlerFunc {
+	return middleware(func(*gin.Context) *ratelimiter.RateLimiter { return rl })
+}
+
+// KeyedMiddleware is like Middleware but limits every client separately, as
+// identified by key, e.g. gin.Context.ClientIP.
+func KeyedMiddleware(kl *ratelimiter.KeyedRateLimiter[string], key func(*gin.Context) string) gin.HandlerFunc {
+	return middleware(func(c *gin.Context) *ratelimiter.RateLimiter { return kl.Limiter(key(c)) })
+}
+
+func middleware(limiter func(*gin.Context) *ratelimiter.RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rl := limiter(c)
+		if !rl.Use() {
+			c.Header("Retry-After", retryAfter(rl))
+			c.AbortWithStatus(http.StatusTooManyRequests)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// retryAfter returns the seconds until rl has a token again, rounded up and
+// never less than one so that clients always back off.
+func retryAfter(rl *ratelimiter.RateLimiter) string {
+	return strconv.Itoa(max(int(math.Ceil(rl.TimeToNext().Seconds())), 1))
+}
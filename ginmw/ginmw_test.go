@@ -0,0 +1,71 @@
+package ginmw
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/joohnes/ratelimiter"
+)
+
+func newRouter(mw gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(mw)
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return r
+}
+
+func get(r http.Handler, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = remoteAddr
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMiddleware(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rl := ratelimiter.NewRateLimiterWithBurst(ctx, ratelimiter.Options{BurstAmount: 2, Interval: 10 * time.Second})
+	r := newRouter(Middleware(rl))
+
+	for i := range 2 {
+		if rec := get(r, "192.0.2.1:1234"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d under the limit: status %d, want 200", i, rec.Code)
+		}
+	}
+	rec := get(r, "192.0.2.1:1234")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the limit: status %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "10" {
+		t.Fatalf("Retry-After = %q, want 10", got)
+	}
+}
+
+func TestKeyedMiddleware(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	kl := ratelimiter.NewKeyedRateLimiter[string](ctx, ratelimiter.KeyedOptions{
+		Options: ratelimiter.Options{BurstAmount: 1, Interval: time.Hour},
+	})
+	defer kl.Close()
+	r := newRouter(KeyedMiddleware(kl, (*gin.Context).ClientIP))
+
+	if rec := get(r, "192.0.2.1:1234"); rec.Code != http.StatusOK {
+		t.Fatalf("first request of a client: status %d, want 200", rec.Code)
+	}
+	if rec := get(r, "192.0.2.1:1234"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request of a client: status %d, want 429", rec.Code)
+	}
+	if rec := get(r, "192.0.2.2:1234"); rec.Code != http.StatusOK {
+		t.Fatalf("first request of another client: status %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,18 @@
+module github.com/joohnes/ratelimiter/grpcmw
+
+go 1.22.0
+
+require (
+	github.com/joohnes/ratelimiter v0.0.0-00010101000000-000000000000
+	google.golang.org/grpc v1.69.4
+)
+
+require (
+	golang.org/x/net v0.33.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20241015192408-796eee8c2d53 // indirect
+	google.golang.org/protobuf v1.36.5 // indirect
+)
+
+replace github.com/joohnes/ratelimiter => ../
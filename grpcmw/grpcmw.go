@@ -0,0 +1,105 @@
+// Package grpcmw provides gRPC server interceptors backed by a
+// ratelimiter.RateLimiter. It lives in its own module so that the core
+// ratelimiter module does not depend on gRPC.
+package grpcmw
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/joohnes/ratelimiter"
+)
+
+// Options holds the options for the interceptors
+//
+// # MaxWait is how long a call may block waiting for a token before it is
+// rejected, zero rejects immediately
+type Options struct {
+	MaxWait time.Duration
+}
+
+// UnaryServerInterceptor consumes a token from rl for every unary call,
+// rejecting the call with codes.ResourceExhausted if none becomes available
+// within MaxWait. It never waits past the call's own deadline.
+func UnaryServerInterceptor(rl *ratelimiter.RateLimiter, opts Options) grpc.UnaryServerInterceptor {
+	return unary(func(ctx context.Context) error { return rl.Wait(ctx) }, opts)
+}
+
+// KeyedUnaryServerInterceptor is like UnaryServerInterceptor but limits every
+// caller separately, keyed by the first value of metadataKey in the incoming
+// metadata, e.g. an API key. Calls without that metadata share the empty key.
+func KeyedUnaryServerInterceptor(kl *ratelimiter.KeyedRateLimiter[string], metadataKey string, opts Options) grpc.UnaryServerInterceptor {
+	return unary(func(ctx context.Context) error { return kl.Wait(ctx, key(ctx, metadataKey)) }, opts)
+}
+
+// StreamServerInterceptor throttles streams message by message: every
+// RecvMsg and SendMsg on the stream waits for a token from rl, bounded only
+// by the stream's context.
+func StreamServerInterceptor(rl *ratelimiter.RateLimiter) grpc.StreamServerInterceptor {
+	return stream(func(ctx context.Context) error { return rl.Wait(ctx) })
+}
+
+// KeyedStreamServerInterceptor is like StreamServerInterceptor but limits
+// every caller separately, keyed as in KeyedUnaryServerInterceptor.
+func KeyedStreamServerInterceptor(kl *ratelimiter.KeyedRateLimiter[string], metadataKey string) grpc.StreamServerInterceptor {
+	return stream(func(ctx context.Context) error { return kl.Wait(ctx, key(ctx, metadataKey)) })
+}
+
+func unary(wait func(context.Context) error, opts Options) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		waitCtx, cancel := context.WithTimeout(ctx, opts.MaxWait)
+		defer cancel()
+
+		if err := wait(waitCtx); err != nil {
+			return nil, toStatus(ctx, err)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+func stream(wait func(context.Context) error) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &throttledStream{ServerStream: ss, wait: wait})
+	}
+}
+
+type throttledStream struct {
+	grpc.ServerStream
+	wait func(context.Context) error
+}
+
+func (s *throttledStream) RecvMsg(m any) error {
+	if err := s.wait(s.Context()); err != nil {
+		return toStatus(s.Context(), err)
+	}
+	return s.ServerStream.RecvMsg(m)
+}
+
+func (s *throttledStream) SendMsg(m any) error {
+	if err := s.wait(s.Context()); err != nil {
+		return toStatus(s.Context(), err)
+	}
+	return s.ServerStream.SendMsg(m)
+}
+
+// toStatus converts a failed wait into a gRPC status error. If the call's
+// own context is done that is reported instead of exhaustion.
+func toStatus(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	return status.Error(codes.ResourceExhausted, "rate limit exceeded")
+}
+
+func key(ctx context.Context, metadataKey string) string {
+	if values := metadata.ValueFromIncomingContext(ctx, metadataKey); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
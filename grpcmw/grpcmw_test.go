@@ -0,0 +1,199 @@
+package grpcmw
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/joohnes/ratelimiter"
+)
+
+// newHealthClient serves the standard health service over an in-memory
+// connection, with the given interceptor in front of it.
+func newHealthClient(t *testing.T, opt grpc.ServerOption) healthpb.HealthClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(opt)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return healthpb.NewHealthClient(conn)
+}
+
+func check(ctx context.Context, client healthpb.HealthClient) codes.Code {
+	_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	return status.Code(err)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	t.Run("MaxWait zero", func(t *testing.T) {
+		rl := ratelimiter.NewRateLimiterWithBurst(ctx, ratelimiter.Options{BurstAmount: 2, Interval: time.Hour})
+		client := newHealthClient(t, grpc.UnaryInterceptor(UnaryServerInterceptor(rl, Options{})))
+
+		for i := range 2 {
+			if code := check(ctx, client); code != codes.OK {
+				t.Fatalf("call %d under the limit: %v, want OK", i, code)
+			}
+		}
+		if code := check(ctx, client); code != codes.ResourceExhausted {
+			t.Fatalf("call over the limit: %v, want ResourceExhausted", code)
+		}
+	})
+
+	t.Run("waits up to MaxWait", func(t *testing.T) {
+		rl := ratelimiter.NewRateLimiterWithBurst(ctx, ratelimiter.Options{BurstAmount: 1, Interval: 50 * time.Millisecond})
+		client := newHealthClient(t, grpc.UnaryInterceptor(UnaryServerInterceptor(rl, Options{MaxWait: time.Second})))
+
+		rl.Drain()
+		if code := check(ctx, client); code != codes.OK {
+			t.Fatalf("call with a refill within MaxWait: %v, want OK", code)
+		}
+	})
+
+	t.Run("rejects after MaxWait", func(t *testing.T) {
+		rl := ratelimiter.NewRateLimiterWithBurst(ctx, ratelimiter.Options{BurstAmount: 1, Interval: time.Hour})
+		client := newHealthClient(t, grpc.UnaryInterceptor(UnaryServerInterceptor(rl, Options{MaxWait: 50 * time.Millisecond})))
+
+		rl.Drain()
+		start := time.Now()
+		if code := check(ctx, client); code != codes.ResourceExhausted {
+			t.Fatalf("call without a refill within MaxWait: %v, want ResourceExhausted", code)
+		}
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+			t.Fatalf("rejected after %v, before MaxWait", elapsed)
+		}
+	})
+
+	t.Run("incoming deadline shorter than MaxWait", func(t *testing.T) {
+		rl := ratelimiter.NewRateLimiterWithBurst(ctx, ratelimiter.Options{BurstAmount: 1, Interval: time.Hour})
+		client := newHealthClient(t, grpc.UnaryInterceptor(UnaryServerInterceptor(rl, Options{MaxWait: time.Hour})))
+
+		rl.Drain()
+		callCtx, callCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer callCancel()
+
+		start := time.Now()
+		if code := check(callCtx, client); code != codes.DeadlineExceeded {
+			t.Fatalf("call with a short deadline: %v, want DeadlineExceeded", code)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Fatalf("call blocked for %v, past its own deadline", elapsed)
+		}
+	})
+}
+
+func TestKeyedUnaryServerInterceptor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	kl := ratelimiter.NewKeyedRateLimiter[string](ctx, ratelimiter.KeyedOptions{
+		Options: ratelimiter.Options{BurstAmount: 1, Interval: time.Hour},
+	})
+	defer kl.Close()
+	client := newHealthClient(t, grpc.UnaryInterceptor(KeyedUnaryServerInterceptor(kl, "api-key", Options{})))
+
+	alice := metadata.AppendToOutgoingContext(ctx, "api-key", "alice")
+	bob := metadata.AppendToOutgoingContext(ctx, "api-key", "bob")
+	if code := check(alice, client); code != codes.OK {
+		t.Fatalf("first call of alice: %v, want OK", code)
+	}
+	if code := check(alice, client); code != codes.ResourceExhausted {
+		t.Fatalf("second call of alice: %v, want ResourceExhausted", code)
+	}
+	if code := check(bob, client); code != codes.OK {
+		t.Fatalf("first call of bob: %v, want OK", code)
+	}
+}
+
+// mockStream is a server stream that counts the messages passed through it.
+type mockStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent int
+}
+
+func (s *mockStream) Context() context.Context { return s.ctx }
+
+func (s *mockStream) SendMsg(any) error {
+	s.sent++
+	return nil
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const messages, interval = 20, 10 * time.Millisecond
+	rl := ratelimiter.NewRateLimiterWithBurst(ctx, ratelimiter.Options{BurstAmount: 1, Interval: interval})
+	interceptor := StreamServerInterceptor(rl)
+
+	ss := &mockStream{ctx: ctx}
+	start := time.Now()
+	err := interceptor(nil, ss, &grpc.StreamServerInfo{}, func(_ any, stream grpc.ServerStream) error {
+		for range messages {
+			if err := stream.SendMsg(nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ss.sent != messages {
+		t.Fatalf("sent %d messages, want %d", ss.sent, messages)
+	}
+	// The first message uses the initial token, every other one waits for
+	// a refill.
+	if elapsed, want := time.Since(start), (messages-1)*interval; elapsed < want {
+		t.Fatalf("sent %d messages in %v, want at least %v", messages, elapsed, want)
+	}
+}
+
+func TestStreamServerInterceptorCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rl := ratelimiter.NewRateLimiterWithBurst(ctx, ratelimiter.Options{BurstAmount: 1, Interval: time.Hour})
+	interceptor := StreamServerInterceptor(rl)
+
+	streamCtx, streamCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer streamCancel()
+	ss := &mockStream{ctx: streamCtx}
+	err := interceptor(nil, ss, &grpc.StreamServerInfo{}, func(_ any, stream grpc.ServerStream) error {
+		for {
+			if err := stream.SendMsg(nil); err != nil {
+				return err
+			}
+		}
+	})
+	if code := status.Code(err); code != codes.DeadlineExceeded {
+		t.Fatalf("stream past its deadline: %v, want DeadlineExceeded", code)
+	}
+	if ss.sent != 1 {
+		t.Fatalf("sent %d messages, want 1", ss.sent)
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/joohnes/ratelimiter/guard
+
+go 1.22.0
+
+require (
+	github.com/joohnes/ratelimiter v0.0.0-00010101000000-000000000000
+	golang.org/x/sync v0.10.0
+)
+
+replace github.com/joohnes/ratelimiter => ../
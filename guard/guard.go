@@ -0,0 +1,55 @@
+// Package guard combines a ratelimiter.RateLimiter with a semaphore, to
+// limit both how often work starts and how much of it is in flight at once.
+// It lives in its own module so that the core ratelimiter module does not
+// depend on golang.org/x/sync.
+package guard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"golang.org/x/sync/semaphore"
+
+	"github.com/joohnes/ratelimiter"
+)
+
+// Guard admits work that passes both a rate limit and a concurrency limit,
+// e.g. at most R calls per second of which at most N are running.
+type Guard struct {
+	rl  *ratelimiter.RateLimiter
+	sem *semaphore.Weighted
+}
+
+// New returns a Guard that takes a token from rl for every Acquire and lets
+// at most n units of weight be acquired at once.
+func New(rl *ratelimiter.RateLimiter, n int64) *Guard {
+	return &Guard{rl: rl, sem: semaphore.NewWeighted(n)}
+}
+
+// Acquire blocks until it has consumed a token from the limiter and then
+// acquired weight from the semaphore, in that order, so that calls held up
+// by the rate limit do not occupy concurrency meanwhile. If ctx is done or
+// the limiter is closed first it returns the error, like
+// ratelimiter.RateLimiter.Wait, leaving nothing consumed: a token already
+// taken is refunded if the semaphore cannot be acquired. Every successful
+// Acquire must be followed by a Release of the same weight.
+func (g *Guard) Acquire(ctx context.Context, weight int64) error {
+	if err := g.rl.Wait(ctx); err != nil {
+		return err
+	}
+	if err := g.sem.Acquire(ctx, weight); err != nil {
+		g.rl.Refund(1)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("%w: %w", ratelimiter.ErrWaitTimeout, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// Release gives back weight acquired by Acquire. The rate limit token is
+// spent, as the work it admitted has started.
+func (g *Guard) Release(weight int64) {
+	g.sem.Release(weight)
+}
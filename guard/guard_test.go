@@ -0,0 +1,46 @@
+package guard
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/joohnes/ratelimiter"
+)
+
+func TestGuard(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rl := ratelimiter.NewRateLimiterWithBurst(ctx, ratelimiter.Options{BurstAmount: 2, Interval: time.Hour})
+	g := New(rl, 1)
+
+	if err := g.Acquire(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// The semaphore is full, so the token taken for the second call is
+	// refunded when it gives up.
+	timeout, stop := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer stop()
+	if err := g.Acquire(timeout, 1); !errors.Is(err, ratelimiter.ErrWaitTimeout) {
+		t.Fatalf("Acquire with the semaphore full = %v, want ErrWaitTimeout", err)
+	}
+	if got := rl.CurrentBurst(); got != 1 {
+		t.Fatalf("CurrentBurst after a timed out Acquire = %d, want 1", got)
+	}
+
+	g.Release(1)
+	if err := g.Acquire(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	g.Release(1)
+
+	// Now the rate limit holds the third call up.
+	timeout, stop = context.WithTimeout(ctx, 20*time.Millisecond)
+	defer stop()
+	if err := g.Acquire(timeout, 1); !errors.Is(err, ratelimiter.ErrWaitTimeout) {
+		t.Fatalf("Acquire with the limiter empty = %v, want ErrWaitTimeout", err)
+	}
+}
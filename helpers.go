@@ -0,0 +1,114 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// WaitEach paces a loop over items through l: it waits for a token before
+// calling fn on each item, and stops at the first error from fn or from the
+// wait, e.g. when ctx is cancelled.
+func WaitEach[T any](ctx context.Context, l Limiter, items []T, fn func(T) error) error {
+	for _, item := range items {
+		if err := l.Wait(ctx); err != nil {
+			return err
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Do waits for a token and then runs fn, returning its error. If no token
+// could be acquired, fn is not run and the error from Wait is returned.
+func (rl *RateLimiter) Do(ctx context.Context, fn func() error) error {
+	return rl.DoN(ctx, 1, fn)
+}
+
+// DoN is like Do but consumes n tokens, see WaitN.
+func (rl *RateLimiter) DoN(ctx context.Context, n int, fn func() error) error {
+	if err := rl.WaitN(ctx, n); err != nil {
+		return err
+	}
+	return fn()
+}
+
+// Parallel is like WaitEach but runs fn on up to workers goroutines at once,
+// each waiting for a token from rl before taking the next item. The context
+// passed to fn is cancelled on the first error, which stops the remaining
+// work and is returned once all workers have exited. workers < 1 is treated
+// as 1.
+func Parallel[T any](ctx context.Context, rl *RateLimiter, items []T, workers int, fn func(context.Context, T) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	next := make(chan T)
+	for range max(min(workers, len(items)), 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range next {
+				if err := rl.Wait(ctx); err != nil {
+					fail(err)
+					return
+				}
+				if err := fn(ctx, item); err != nil {
+					fail(err)
+					return
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, item := range items {
+		select {
+		case next <- item:
+		case <-ctx.Done():
+			// Either a worker failed or the parent context is done.
+			fail(ctxErr(ctx))
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	return firstErr
+}
+
+// Throttle returns a channel that receives the time every time a token has
+// been consumed from rl, as a replacement for time.Tick that follows the
+// limiter's configuration. Like RateLimiter.C, a token is taken before each
+// send, so at most one is held by the channel at a time. The channel is
+// closed, and its goroutine exits, once ctx is done or rl is closed.
+func Throttle(ctx context.Context, rl *RateLimiter) <-chan time.Time {
+	ch := make(chan time.Time)
+	go func() {
+		defer close(ch)
+		for {
+			if err := rl.Wait(ctx); err != nil {
+				return
+			}
+			select {
+			case ch <- rl.clock.Now():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
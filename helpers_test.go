@@ -0,0 +1,237 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	check := checkGoroutines(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	clk := newFakeClock()
+	rl := newFakeLimiter(ctx, Options{BurstAmount: 1, Interval: time.Second}, clk)
+	ticks := Throttle(ctx, rl)
+
+	start := clk.Now()
+	<-ticks
+	for i := 1; i <= 3; i++ {
+		clk.Advance(time.Second)
+		if got, want := (<-ticks).Sub(start), time.Duration(i)*time.Second; got != want {
+			t.Fatalf("tick %d at %v, want %v", i, got, want)
+		}
+	}
+
+	cancel()
+	for range ticks {
+		// Drain a tick sent before cancel, if any.
+	}
+	check()
+}
+
+func TestWaitEach(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	items := []int{1, 2, 3, 4, 5}
+	t.Run("completion", func(t *testing.T) {
+		clk := newFakeClock()
+		rl := newFakeLimiter(ctx, Options{BurstAmount: 5, Interval: time.Hour}, clk)
+		var got []int
+		err := WaitEach(ctx, rl, items, func(i int) error {
+			got = append(got, i)
+			return nil
+		})
+		if err != nil || !slices.Equal(got, items) {
+			t.Fatalf("WaitEach = %v after %v, want nil after %v", err, got, items)
+		}
+		if n := rl.CurrentBurst(); n != 0 {
+			t.Fatalf("CurrentBurst after 5 items = %d, want a token spent on each", n)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		rl := newFakeLimiter(ctx, Options{BurstAmount: 5, Interval: time.Hour}, newFakeClock())
+		failed := errors.New("item failed")
+		var got []int
+		err := WaitEach(ctx, rl, items, func(i int) error {
+			got = append(got, i)
+			if i == 2 {
+				return failed
+			}
+			return nil
+		})
+		if err != failed || !slices.Equal(got, []int{1, 2}) {
+			t.Fatalf("WaitEach = %v after %v, want %v after [1 2]", err, got, failed)
+		}
+	})
+
+	t.Run("cancellation", func(t *testing.T) {
+		rl := newFakeLimiter(ctx, Options{BurstAmount: 2, Interval: time.Hour}, newFakeClock())
+		loopCtx, cancelLoop := context.WithCancel(ctx)
+		var got []int
+		errs := make(chan error, 1)
+		go func() {
+			errs <- WaitEach(loopCtx, rl, items, func(i int) error {
+				got = append(got, i)
+				return nil
+			})
+		}()
+
+		// The third item waits for a refill an hour away.
+		eventually(t, func() bool { return rl.Stats().WaitersBlocked == 1 })
+		cancelLoop()
+		if err := <-errs; !errors.Is(err, context.Canceled) || !slices.Equal(got, []int{1, 2}) {
+			t.Fatalf("WaitEach = %v after %v, want context.Canceled after [1 2]", err, got)
+		}
+	})
+}
+
+func TestDo(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	rl := newFakeLimiter(ctx, Options{BurstAmount: 3, Interval: time.Second}, clk)
+	rl.Drain()
+
+	// fn only runs once the token is there.
+	var ran atomic.Bool
+	errs := make(chan error, 1)
+	go func() {
+		errs <- rl.Do(ctx, func() error {
+			if rl.Stats().WaitersBlocked != 0 {
+				t.Error("fn ran while its Do was still waiting")
+			}
+			ran.Store(true)
+			return nil
+		})
+	}()
+	eventually(t, func() bool { return rl.Stats().WaitersBlocked == 1 })
+	if ran.Load() {
+		t.Fatal("fn ran before a token was acquired")
+	}
+	clk.Advance(time.Second)
+	if err := <-errs; err != nil || !ran.Load() {
+		t.Fatalf("Do after a refill = %v with fn run %v, want nil with fn run", err, ran.Load())
+	}
+
+	// DoN passes on the error from fn, after taking n tokens.
+	clk.Advance(2 * time.Second)
+	failed := errors.New("failed")
+	if err := rl.DoN(ctx, 2, func() error { return failed }); err != failed {
+		t.Fatalf("DoN = %v, want the error from fn", err)
+	}
+	if got := rl.CurrentBurst(); got != 0 {
+		t.Fatalf("CurrentBurst after DoN(2) = %d, want 0", got)
+	}
+
+	cancelled, cancelDo := context.WithCancel(ctx)
+	cancelDo()
+	err := rl.Do(cancelled, func() error {
+		t.Error("fn ran with the context cancelled before a token")
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Do with a cancelled context = %v, want context.Canceled", err)
+	}
+	if err := rl.DoN(ctx, 4, func() error {
+		t.Error("fn ran for more tokens than the burst")
+		return nil
+	}); !errors.Is(err, ErrExceedsBurst) {
+		t.Fatalf("DoN above MaxBurst = %v, want ErrExceedsBurst", err)
+	}
+}
+
+func TestParallelConcurrency(t *testing.T) {
+	rl := newFakeLazyLimiter(Options{BurstAmount: 100, Interval: time.Hour}, newFakeClock())
+
+	var running, peak, calls atomic.Int64
+	err := Parallel(context.Background(), rl, make([]int, 20), 3, func(context.Context, int) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		calls.Add(1)
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := calls.Load(); got != 20 {
+		t.Fatalf("fn ran %d times for 20 items", got)
+	}
+	if got := peak.Load(); got > 3 {
+		t.Fatalf("%d calls of fn ran at once with 3 workers", got)
+	}
+}
+
+func TestParallelPacing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	rl := newFakeLimiter(ctx, Options{BurstAmount: 1, Interval: time.Second}, clk)
+
+	var calls atomic.Int64
+	errs := make(chan error, 1)
+	go func() {
+		errs <- Parallel(ctx, rl, make([]int, 5), 5, func(context.Context, int) error {
+			calls.Add(1)
+			return nil
+		})
+	}()
+
+	// However many workers there are, every refill admits a single item.
+	for want := int64(1); want <= 5; want++ {
+		eventually(t, func() bool { return calls.Load() == want })
+		if want < 5 {
+			eventually(t, func() bool { return rl.Stats().WaitersBlocked == int(5-want) })
+			if got := calls.Load(); got != want {
+				t.Fatalf("%d items ran after %d refills, want %d", got, want-1, want)
+			}
+			clk.Advance(time.Second)
+		}
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParallelFirstError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rl := newFakeLimiter(ctx, Options{BurstAmount: 3, Interval: time.Hour}, newFakeClock())
+	failed := errors.New("item failed")
+
+	var calls atomic.Int64
+	errs := make(chan error, 1)
+	go func() {
+		errs <- Parallel(ctx, rl, make([]int, 10), 2, func(ctx context.Context, _ int) error {
+			if calls.Add(1) == 3 {
+				return failed
+			}
+			return nil
+		})
+	}()
+
+	// The other worker is left waiting for a refill an hour away, until the
+	// error cancels it.
+	select {
+	case err := <-errs:
+		if err != failed {
+			t.Fatalf("Parallel = %v, want the first error from fn", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Parallel kept waiting after fn failed")
+	}
+	if got := calls.Load(); got != 3 {
+		t.Fatalf("fn ran %d times, want it to stop at the failing third call", got)
+	}
+}
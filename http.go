@@ -0,0 +1,178 @@
+package ratelimiter
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// MiddlewareOptions holds the names of the headers set by the middleware,
+// so they can match GitHub style or draft IETF naming. Empty names fall back
+// to the defaults
+//
+// # LimitHeader carries MaxBurst, defaults to X-RateLimit-Limit
+//
+// # RemainingHeader carries the tokens left after the request, defaults to
+// X-RateLimit-Remaining
+//
+// # ResetHeader carries the seconds until the next refill, defaults to
+// X-RateLimit-Reset
+//
+// # RetryAfterHeader carries the seconds until a rejected client may retry,
+// defaults to Retry-After
+type MiddlewareOptions struct {
+	LimitHeader      string
+	RemainingHeader  string
+	ResetHeader      string
+	RetryAfterHeader string
+}
+
+func (o MiddlewareOptions) withDefaults() MiddlewareOptions {
+	if o.LimitHeader == "" {
+		o.LimitHeader = "X-RateLimit-Limit"
+	}
+	if o.RemainingHeader == "" {
+		o.RemainingHeader = "X-RateLimit-Remaining"
+	}
+	if o.ResetHeader == "" {
+		o.ResetHeader = "X-RateLimit-Reset"
+	}
+	if o.RetryAfterHeader == "" {
+		o.RetryAfterHeader = "Retry-After"
+	}
+	return o
+}
+
+// Middleware consumes a token for every request passed to next. When none is
+// available it responds with 429 Too Many Requests and a Retry-After header
+// telling the client when the next token will be. Every response carries
+// the rate limit headers described by MiddlewareOptions, and the request
+// context passed to next carries the Decision.
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return rl.MiddlewareWithOptions(MiddlewareOptions{})(next)
+}
+
+// MiddlewareWithOptions is like Middleware but with custom header names.
+func (rl *RateLimiter) MiddlewareWithOptions(opts MiddlewareOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return middleware(func(*http.Request) *RateLimiter { return rl }, opts, next)
+	}
+}
+
+// KeyedMiddleware is like Middleware but limits every client separately, as
+// identified by key, e.g. the client IP.
+func KeyedMiddleware(kl *KeyedRateLimiter[string], key func(*http.Request) string) func(http.Handler) http.Handler {
+	return KeyedMiddlewareWithOptions(kl, key, MiddlewareOptions{})
+}
+
+// KeyedMiddlewareWithOptions is like KeyedMiddleware but with custom header
+// names.
+func KeyedMiddlewareWithOptions(kl *KeyedRateLimiter[string], key func(*http.Request) string, opts MiddlewareOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return middleware(func(r *http.Request) *RateLimiter { return kl.get(key(r)) }, opts, next)
+	}
+}
+
+// SelectMiddleware is like Middleware but lets selector pick the limiter for
+// every request, e.g. to give routes or methods limits of their own. Requests
+// for which selector returns false pass through unthrottled and without rate
+// limit headers.
+func SelectMiddleware(selector func(*http.Request) (*RateLimiter, bool)) func(http.Handler) http.Handler {
+	return SelectMiddlewareWithOptions(selector, MiddlewareOptions{})
+}
+
+// SelectMiddlewareWithOptions is like SelectMiddleware but with custom header
+// names.
+func SelectMiddlewareWithOptions(selector func(*http.Request) (*RateLimiter, bool), opts MiddlewareOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return middleware(func(r *http.Request) *RateLimiter {
+			if rl, ok := selector(r); ok {
+				return rl
+			}
+			return nil
+		}, opts, next)
+	}
+}
+
+// middleware limits the requests passed to next with the limiter returned
+// for each of them, passing them through if it is nil.
+func middleware(limiter func(*http.Request) *RateLimiter, opts MiddlewareOptions, next http.Handler) http.Handler {
+	opts = opts.withDefaults()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rl := limiter(r)
+		if rl == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// The decision and the headers describing it are made at the same
+		// instant, so they cannot contradict each other.
+		now := rl.clock.Now()
+		allowed, _ := rl.use(1, now)
+
+		limit, remaining, reset := rl.headerState(now)
+		h := w.Header()
+		h.Set(opts.LimitHeader, strconv.Itoa(limit))
+		h.Set(opts.RemainingHeader, strconv.Itoa(remaining))
+		h.Set(opts.ResetHeader, strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+
+		if !allowed {
+			h.Set(opts.RetryAfterHeader, strconv.Itoa(seconds(rl.delay(now))))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(ContextWithDecision(r.Context(), true, remaining)))
+	})
+}
+
+// headerState returns the max burst, the tokens left and the time from now
+// until the next refill in one consistent read.
+func (rl *RateLimiter) headerState(now time.Time) (int, int, time.Duration) {
+	rl.lock()
+	defer rl.unlock()
+
+	rl.refill(now)
+
+	var reset time.Duration
+	if rl.burst < rl.capacity() {
+		reset = max(rl.due(float64(rl.whole()+1), now).Sub(now), 0)
+	}
+
+	return rl.maxBurst, max(rl.whole(), 0), reset
+}
+
+// seconds rounds d up to whole seconds, never returning less than one so
+// that clients always back off.
+func seconds(d time.Duration) int {
+	return max(int(math.Ceil(d.Seconds())), 1)
+}
+
+// NewTransport returns an http.RoundTripper that waits for a token before
+// sending every request through base, so that a client paces itself against
+// the limits of the API it calls. A nil base means http.DefaultTransport.
+// The wait is cut short by the request's context, in which case its error
+// is returned and the request is not sent.
+func NewTransport(base http.RoundTripper, rl *RateLimiter) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{base: base, rl: rl}
+}
+
+type transport struct {
+	base http.RoundTripper
+	rl   *RateLimiter
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.rl.Wait(req.Context()); err != nil {
+		// A RoundTripper must close the body even when it fails.
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
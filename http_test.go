@@ -0,0 +1,260 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+func serve(h http.Handler, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func TestMiddleware(t *testing.T) {
+	clk := newFakeClock()
+	rl := newFakeLazyLimiter(Options{BurstAmount: 2, Interval: 10 * time.Second}, clk)
+	h := rl.Middleware(okHandler)
+
+	for i := range 2 {
+		if rec := serve(h, "/"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d under the limit: status %d, want 200", i, rec.Code)
+		}
+	}
+
+	rec := serve(h, "/")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the limit: status %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "10" {
+		t.Fatalf("Retry-After = %q, want 10", got)
+	}
+
+	clk.Advance(4 * time.Second)
+	if got := serve(h, "/").Header().Get("Retry-After"); got != "6" {
+		t.Fatalf("Retry-After 4s later = %q, want 6", got)
+	}
+
+	clk.Advance(6 * time.Second)
+	if rec := serve(h, "/"); rec.Code != http.StatusOK {
+		t.Fatalf("request after Retry-After: status %d, want 200", rec.Code)
+	}
+}
+
+// headerInt parses the integer value of header name in rec.
+func headerInt(t *testing.T, rec *httptest.ResponseRecorder, name string) int {
+	t.Helper()
+
+	v, err := strconv.Atoi(rec.Header().Get(name))
+	if err != nil {
+		t.Fatalf("header %s: %v", name, err)
+	}
+	return v
+}
+
+func TestMiddlewareHeaders(t *testing.T) {
+	clk := newFakeClock()
+	rl := newFakeLazyLimiter(Options{BurstAmount: 3, Interval: 10 * time.Second}, clk)
+
+	var decision Decision
+	h := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		decision, _ = DecisionFromContext(r.Context())
+	}))
+
+	last := 3
+	for i := range 3 {
+		rec := serve(h, "/")
+		if limit := headerInt(t, rec, "X-RateLimit-Limit"); limit != 3 {
+			t.Fatalf("request %d: X-RateLimit-Limit = %d, want 3", i, limit)
+		}
+		remaining := headerInt(t, rec, "X-RateLimit-Remaining")
+		if remaining != last-1 {
+			t.Fatalf("request %d: X-RateLimit-Remaining = %d, want %d", i, remaining, last-1)
+		}
+		if decision != (Decision{Allowed: true, Remaining: remaining}) {
+			t.Fatalf("request %d: decision %+v does not match the headers", i, decision)
+		}
+		if reset := headerInt(t, rec, "X-RateLimit-Reset"); reset != 10 {
+			t.Fatalf("request %d: X-RateLimit-Reset = %d, want 10", i, reset)
+		}
+		last = remaining
+	}
+
+	clk.Advance(4 * time.Second)
+	rec := serve(h, "/")
+	if remaining := headerInt(t, rec, "X-RateLimit-Remaining"); remaining != 0 {
+		t.Fatalf("rejected request: X-RateLimit-Remaining = %d, want 0", remaining)
+	}
+	if reset := headerInt(t, rec, "X-RateLimit-Reset"); reset != 6 {
+		t.Fatalf("rejected request: X-RateLimit-Reset = %d, want 6", reset)
+	}
+}
+
+func TestMiddlewareCustomHeaders(t *testing.T) {
+	clk := newFakeClock()
+	rl := newFakeLazyLimiter(Options{BurstAmount: 1, Interval: 10 * time.Second}, clk)
+	opts := MiddlewareOptions{
+		LimitHeader:      "RateLimit-Limit",
+		RemainingHeader:  "RateLimit-Remaining",
+		ResetHeader:      "RateLimit-Reset",
+		RetryAfterHeader: "X-Retry-In",
+	}
+	h := rl.MiddlewareWithOptions(opts)(okHandler)
+
+	serve(h, "/")
+	rec := serve(h, "/")
+	for name, want := range map[string]string{
+		"RateLimit-Limit":     "1",
+		"RateLimit-Remaining": "0",
+		"RateLimit-Reset":     "10",
+		"X-Retry-In":          "10",
+	} {
+		if got := rec.Header().Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	for _, name := range []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After"} {
+		if got := rec.Header().Get(name); got != "" {
+			t.Errorf("default header %s set to %q alongside the custom one", name, got)
+		}
+	}
+}
+
+// newCountingServer returns a test server counting the requests it got.
+func newCountingServer(t *testing.T) (*httptest.Server, *atomic.Int64) {
+	var hits atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func TestTransport(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	rl := newFakeLimiter(ctx, Options{BurstAmount: 1, Interval: 10 * time.Second}, clk)
+	srv, hits := newCountingServer(t)
+	client := &http.Client{Transport: NewTransport(nil, rl)}
+
+	get := func() error {
+		resp, err := client.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	if err := get(); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- get() }()
+	eventually(t, func() bool { return rl.Stats().WaitersBlocked == 1 })
+	if n := hits.Load(); n != 1 {
+		t.Fatalf("server got %d requests before the refill, want 1", n)
+	}
+
+	clk.Advance(10 * time.Second)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n := hits.Load(); n != 2 {
+		t.Fatalf("server got %d requests after the refill, want 2", n)
+	}
+}
+
+// closeRecorder is a request body recording whether it was closed.
+type closeRecorder struct {
+	*strings.Reader
+	closed bool
+}
+
+func (r *closeRecorder) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestTransportCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rl := newFakeLimiter(ctx, Options{BurstAmount: 1, Interval: time.Hour}, newFakeClock())
+	rl.Drain()
+	srv, hits := newCountingServer(t)
+	transport := NewTransport(nil, rl)
+
+	reqCtx, reqCancel := context.WithCancel(ctx)
+	body := &closeRecorder{Reader: strings.NewReader("payload")}
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, srv.URL, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := transport.RoundTrip(req)
+		done <- err
+	}()
+	eventually(t, func() bool { return rl.Stats().WaitersBlocked == 1 })
+	reqCancel()
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("RoundTrip with a cancelled request = %v, want context.Canceled", err)
+	}
+	if !body.closed {
+		t.Fatal("RoundTrip did not close the body of the unsent request")
+	}
+	if n := hits.Load(); n != 0 {
+		t.Fatalf("server got %d requests, want the cancelled one unsent", n)
+	}
+}
+
+func TestSelectMiddleware(t *testing.T) {
+	clk := newFakeClock()
+	limiters := map[string]*RateLimiter{
+		"/a": newFakeLazyLimiter(Options{BurstAmount: 1, Interval: time.Hour}, clk),
+		"/b": newFakeLazyLimiter(Options{BurstAmount: 2, Interval: time.Hour}, clk),
+	}
+	h := SelectMiddleware(func(r *http.Request) (*RateLimiter, bool) {
+		rl, ok := limiters[r.URL.Path]
+		return rl, ok
+	})(okHandler)
+
+	for _, step := range []struct {
+		path string
+		want int
+	}{
+		{"/a", http.StatusOK},
+		{"/a", http.StatusTooManyRequests},
+		{"/b", http.StatusOK},
+		{"/b", http.StatusOK},
+		{"/b", http.StatusTooManyRequests},
+		{"/a", http.StatusTooManyRequests},
+	} {
+		if rec := serve(h, step.path); rec.Code != step.want {
+			t.Fatalf("GET %s: status %d, want %d", step.path, rec.Code, step.want)
+		}
+	}
+
+	for range 5 {
+		rec := serve(h, "/health")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /health: status %d, want it unthrottled", rec.Code)
+		}
+		if got := rec.Header().Get("X-RateLimit-Limit"); got != "" {
+			t.Fatalf("GET /health: X-RateLimit-Limit = %q, want no rate limit headers", got)
+		}
+	}
+}
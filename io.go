@@ -0,0 +1,90 @@
+package ratelimiter
+
+import (
+	"context"
+	"io"
+)
+
+// ThrottledReader caps the throughput of an io.Reader, consuming one token
+// from its limiter per chunk of bytes read (one byte by default).
+type ThrottledReader struct {
+	r io.Reader
+	throttle
+}
+
+// ThrottledWriter caps the throughput of an io.Writer, consuming one token
+// from its limiter per chunk of bytes written (one byte by default).
+type ThrottledWriter struct {
+	w io.Writer
+	throttle
+}
+
+type throttle struct {
+	rl    *RateLimiter
+	ctx   context.Context
+	chunk int
+}
+
+func NewThrottledReader(r io.Reader, rl *RateLimiter) *ThrottledReader {
+	return &ThrottledReader{r: r, throttle: throttle{rl: rl, ctx: context.Background(), chunk: 1}}
+}
+
+func NewThrottledWriter(w io.Writer, rl *RateLimiter) *ThrottledWriter {
+	return &ThrottledWriter{w: w, throttle: throttle{rl: rl, ctx: context.Background(), chunk: 1}}
+}
+
+// SetContext sets the context used while waiting for tokens, so that a
+// blocked Read or Write can be cancelled.
+func (t *throttle) SetContext(ctx context.Context) {
+	t.ctx = ctx
+}
+
+// SetChunkSize sets how many bytes one token pays for. Values below 1 are
+// treated as 1.
+func (t *throttle) SetChunkSize(n int) {
+	t.chunk = max(n, 1)
+}
+
+// Read reads at most as many bytes as a full burst pays for, then waits for
+// the tokens those bytes cost. If the wait fails, the bytes already read are
+// returned along with the error.
+func (t *ThrottledReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p[:min(len(p), t.maxBytes())])
+	if n > 0 {
+		if werr := t.rl.WaitN(t.ctx, t.tokens(n)); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// Write splits p into pieces no larger than a full burst pays for and waits
+// for the tokens of each piece before writing it.
+func (t *ThrottledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		piece := p[:min(len(p), t.maxBytes())]
+		if err := t.rl.WaitN(t.ctx, t.tokens(len(piece))); err != nil {
+			return written, err
+		}
+
+		n, err := t.w.Write(piece)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if n < len(piece) {
+			return written, io.ErrShortWrite
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (t *throttle) maxBytes() int {
+	return t.rl.MaxBurst() * t.chunk
+}
+
+func (t *throttle) tokens(bytes int) int {
+	return (bytes + t.chunk - 1) / t.chunk
+}
@@ -0,0 +1,168 @@
+package ratelimiter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe for a writer and a reader of Len.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Len()
+}
+
+func TestThrottledWriterRate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	rl := newFakeLimiter(ctx, Options{BurstAmount: 4, Interval: 10 * time.Millisecond, RefillAmount: 4}, clk)
+	var sink syncBuffer
+	w := NewThrottledWriter(&sink, rl)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := w.Write(make([]byte, 20))
+		done <- err
+	}()
+
+	// The full bucket pays for the first 4 bytes, and every refill of 4
+	// tokens for 4 more. Time is frozen in between, so the writer cannot
+	// get past that while it is blocked.
+	for written := 4; written < 20; written += 4 {
+		eventually(t, func() bool { return sink.Len() == written && rl.Stats().WaitersBlocked == 1 })
+		clk.Advance(10 * time.Millisecond)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n := sink.Len(); n != 20 {
+		t.Fatalf("wrote %d bytes, want 20", n)
+	}
+}
+
+func TestThrottledReaderRate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	rl := newFakeLimiter(ctx, Options{BurstAmount: 4, Interval: 10 * time.Millisecond, RefillAmount: 4}, clk)
+	data := []byte("0123456789abcdefghij")
+	r := NewThrottledReader(bytes.NewReader(data), rl)
+
+	chunks := make(chan []byte)
+	go func() {
+		defer close(chunks)
+		for {
+			p := make([]byte, 16)
+			n, err := r.Read(p)
+			if err != nil {
+				if err != io.EOF {
+					t.Error(err)
+				}
+				return
+			}
+			chunks <- p[:n]
+		}
+	}()
+
+	// A Read returns once the bytes are paid for: the first one by the full
+	// bucket and every later one by a refill of 4 tokens.
+	var got []byte
+	for i := range 5 {
+		if i > 0 {
+			eventually(t, func() bool { return rl.Stats().WaitersBlocked == 1 })
+			select {
+			case c := <-chunks:
+				t.Fatalf("read %q before the refill paying for it", c)
+			default:
+			}
+			clk.Advance(10 * time.Millisecond)
+		}
+		c := <-chunks
+		if len(c) != 4 {
+			t.Fatalf("read %d bytes at once, want the 4 a full burst pays for", len(c))
+		}
+		got = append(got, c...)
+	}
+	if _, ok := <-chunks; ok {
+		t.Fatal("read past the end of the data")
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("read %q, want %q", got, data)
+	}
+}
+
+// shortWriter accepts at most n bytes per Write, failing with err once it
+// has none left if err is set.
+type shortWriter struct {
+	n   int
+	err error
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	if len(p) <= w.n {
+		return len(p), nil
+	}
+	return w.n, w.err
+}
+
+func TestThrottledWriterShortWrite(t *testing.T) {
+	rl := newFakeLazyLimiter(Options{BurstAmount: 8, Interval: time.Hour}, newFakeClock())
+
+	w := NewThrottledWriter(&shortWriter{n: 3}, rl)
+	if n, err := w.Write(make([]byte, 5)); n != 3 || err != io.ErrShortWrite {
+		t.Fatalf("short write = %d, %v, want 3, io.ErrShortWrite", n, err)
+	}
+
+	failing := errors.New("disk full")
+	w = NewThrottledWriter(&shortWriter{n: 2, err: failing}, rl)
+	if n, err := w.Write(make([]byte, 3)); n != 2 || err != failing {
+		t.Fatalf("failed write = %d, %v, want 2, %v", n, err, failing)
+	}
+}
+
+func TestThrottledCancelledContext(t *testing.T) {
+	rl := newFakeLazyLimiter(Options{BurstAmount: 4, Interval: time.Hour}, newFakeClock())
+	rl.Drain()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var sink bytes.Buffer
+	w := NewThrottledWriter(&sink, rl)
+	w.SetContext(ctx)
+	if n, err := w.Write([]byte("data")); n != 0 || !errors.Is(err, context.Canceled) {
+		t.Fatalf("Write with a cancelled context = %d, %v, want 0, context.Canceled", n, err)
+	}
+	if sink.Len() != 0 {
+		t.Fatalf("Write with a cancelled context wrote %q", sink.String())
+	}
+
+	// The reader has already read the bytes when the wait fails, so it
+	// returns them along with the error.
+	r := NewThrottledReader(bytes.NewReader([]byte("data")), rl)
+	r.SetContext(ctx)
+	p := make([]byte, 4)
+	if n, err := r.Read(p); n != 4 || !errors.Is(err, context.Canceled) {
+		t.Fatalf("Read with a cancelled context = %d, %v, want 4, context.Canceled", n, err)
+	}
+}
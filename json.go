@@ -0,0 +1,164 @@
+package ratelimiter
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// optionsJSON is the wire form of Options, with durations written as
+// strings such as "1s" or "100ms".
+type optionsJSON struct {
+	BurstAmount            int     `json:"burstAmount"`
+	BurstInterval          string  `json:"burstInterval"`
+	Interval               string  `json:"interval"`
+	RefillAmount           float64 `json:"refillAmount,omitempty"`
+	Jitter                 float64 `json:"jitter,omitempty"`
+	Disabled               bool    `json:"disabled,omitempty"`
+	InitialBurst           *int    `json:"initialBurst,omitempty"`
+	AllowOversizedRequests bool    `json:"allowOversizedRequests,omitempty"`
+}
+
+func (opts Options) MarshalJSON() ([]byte, error) {
+	return json.Marshal(optionsJSON{
+		BurstAmount:            opts.BurstAmount,
+		BurstInterval:          opts.BurstInterval.String(),
+		Interval:               opts.Interval.String(),
+		RefillAmount:           opts.RefillAmount,
+		Jitter:                 opts.Jitter,
+		Disabled:               opts.Disabled,
+		InitialBurst:           opts.InitialBurst,
+		AllowOversizedRequests: opts.AllowOversizedRequests,
+	})
+}
+
+// UnmarshalJSON parses options written by MarshalJSON. Durations that do not
+// parse are an error, while out of range values are corrected the same way
+// the constructors correct them.
+func (opts *Options) UnmarshalJSON(data []byte) error {
+	var raw optionsJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	burstInterval, err := parseDuration(raw.BurstInterval)
+	if err != nil {
+		return err
+	}
+	interval, err := parseDuration(raw.Interval)
+	if err != nil {
+		return err
+	}
+
+	*opts = Options{
+		BurstAmount:            raw.BurstAmount,
+		BurstInterval:          burstInterval,
+		Interval:               interval,
+		RefillAmount:           raw.RefillAmount,
+		Jitter:                 raw.Jitter,
+		Disabled:               raw.Disabled,
+		InitialBurst:           raw.InitialBurst,
+		AllowOversizedRequests: raw.AllowOversizedRequests,
+	}.normalized()
+	return nil
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// State is a snapshot of a limiter that can be persisted and used to
+// construct an equivalent one, or restored into a running one
+//
+// # Options is the configuration of the limiter
+//
+// # Tokens is the number of tokens that were available
+//
+// # Cooldown is the part of the burst cooldown that was still left
+type State struct {
+	Options  Options       `json:"options"`
+	Tokens   int           `json:"tokens"`
+	Cooldown time.Duration `json:"cooldown"`
+}
+
+// stateJSON is the wire form of State, with the cooldown written as a
+// string like the durations in Options.
+type stateJSON struct {
+	Options  Options `json:"options"`
+	Tokens   int     `json:"tokens"`
+	Cooldown string  `json:"cooldown,omitempty"`
+}
+
+func (s State) MarshalJSON() ([]byte, error) {
+	raw := stateJSON{Options: s.Options, Tokens: s.Tokens}
+	if s.Cooldown != 0 {
+		raw.Cooldown = s.Cooldown.String()
+	}
+	return json.Marshal(raw)
+}
+
+func (s *State) UnmarshalJSON(data []byte) error {
+	var raw stateJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	cooldown, err := parseDuration(raw.Cooldown)
+	if err != nil {
+		return err
+	}
+
+	*s = State{Options: raw.Options, Tokens: raw.Tokens, Cooldown: cooldown}
+	return nil
+}
+
+// Snapshot returns the current configuration, token count and remaining
+// burst cooldown.
+func (rl *RateLimiter) Snapshot() State {
+	rl.lock()
+	defer rl.unlock()
+
+	now := rl.clock.Now()
+	rl.refill(now)
+	return State{
+		Options:  rl.options(),
+		Tokens:   max(rl.whole(), 0),
+		Cooldown: max(rl.burstCooldown.Sub(now), 0),
+	}
+}
+
+// Restore applies a State taken by Snapshot, e.g. in a process that took
+// over from another one during a restart, so that it resumes with the same
+// tokens rather than a full bucket. It returns an error wrapping
+// ErrInvalidOptions without changing anything if the state is out of range.
+// Tokens beyond the restored MaxBurst are dropped, and the refill interval
+// starts over from now.
+func (rl *RateLimiter) Restore(s State) error {
+	if err := s.Options.Validate(); err != nil {
+		return err
+	}
+	switch {
+	case s.Tokens < 0:
+		return fmt.Errorf("%w: Tokens must not be negative, got %d", ErrInvalidOptions, s.Tokens)
+	case s.Cooldown < 0:
+		return fmt.Errorf("%w: Cooldown must not be negative, got %s", ErrInvalidOptions, s.Cooldown)
+	}
+
+	opts := s.Options.normalized()
+
+	rl.reconfigure(func() {
+		rl.applyOptions(opts)
+		now := rl.clock.Now()
+		rl.burst = min(float64(s.Tokens), rl.capacity())
+		rl.burstCooldown = now.Add(s.Cooldown)
+		rl.lastRefill = now
+		if rl.ticker != nil && !rl.closed {
+			rl.resetTicker(rl.nextInterval())
+		}
+		rl.notify()
+	})
+	return nil
+}
@@ -0,0 +1,117 @@
+package ratelimiter
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	clk := newFakeClock()
+	src := newFakeLazyLimiter(Options{
+		BurstAmount:            5,
+		BurstInterval:          10 * time.Millisecond,
+		Interval:               time.Second,
+		AllowOversizedRequests: true,
+	}, clk)
+	src.UseN(3)
+
+	data, err := json.Marshal(src.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s State
+	if err := json.Unmarshal(data, &s); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := newFakeLazyLimiter(Options{BurstAmount: 1, Interval: time.Minute}, clk)
+	if err := dst.Restore(s); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := dst.Snapshot().Options, src.Snapshot().Options; got != want {
+		t.Fatalf("Options after Restore = %+v, want %+v", got, want)
+	}
+	if got := dst.Tokens(); got != 2 {
+		t.Fatalf("Tokens after Restore = %g, want 2", got)
+	}
+}
+
+func TestRestoreReportsConfigChange(t *testing.T) {
+	clk := newFakeClock()
+	rl := newFakeLazyLimiter(Options{BurstAmount: 1, Interval: time.Second}, clk)
+
+	var calls []Options
+	rl.SetOnConfigChange(func(old, new Options) {
+		calls = append(calls, new)
+	})
+
+	s := State{Options: Options{BurstAmount: 4, Interval: time.Second}, Tokens: 2}
+	if err := rl.Restore(s); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 1 || calls[0].BurstAmount != 4 {
+		t.Fatalf("OnConfigChange calls after Restore = %+v, want one with a burst of 4", calls)
+	}
+
+	// Restoring the same configuration only changes the tokens.
+	if err := rl.Restore(s); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 1 {
+		t.Fatalf("OnConfigChange called %d times, want once", len(calls))
+	}
+}
+
+func TestOptionsJSON(t *testing.T) {
+	initial := 2
+	opts := Options{
+		BurstAmount:   5,
+		BurstInterval: 100 * time.Millisecond,
+		Interval:      90 * time.Second,
+		RefillAmount:  1.5,
+		Jitter:        0.25,
+		InitialBurst:  &initial,
+	}
+
+	data, err := json.Marshal(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"burstAmount":5,"burstInterval":"100ms","interval":"1m30s","refillAmount":1.5,"jitter":0.25,"initialBurst":2}`
+	if string(data) != want {
+		t.Fatalf("Marshal = %s, want %s", data, want)
+	}
+
+	var got Options
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, opts) {
+		t.Fatalf("round trip = %+v, want %+v", got, opts)
+	}
+}
+
+func TestOptionsJSONInvalid(t *testing.T) {
+	for _, data := range []string{
+		`{"burstAmount":1,"interval":"fast"}`,
+		`{"burstAmount":1,"interval":"1s","burstInterval":"10"}`,
+		`{"burstAmount":"many"}`,
+	} {
+		var opts Options
+		if err := json.Unmarshal([]byte(data), &opts); err == nil {
+			t.Errorf("Unmarshal(%s) = %+v, want an error", data, opts)
+		}
+	}
+
+	// Out of range values are corrected like the constructors do.
+	var opts Options
+	if err := json.Unmarshal([]byte(`{"burstAmount":-3,"interval":"-1s","jitter":2}`), &opts); err != nil {
+		t.Fatal(err)
+	}
+	want := Options{BurstAmount: 1, Interval: time.Second, RefillAmount: 1, Jitter: 1}
+	if !reflect.DeepEqual(opts, want) {
+		t.Fatalf("Unmarshal of out of range values = %+v, want %+v", opts, want)
+	}
+}
@@ -0,0 +1,213 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// KeyedOptions holds the options for a KeyedRateLimiter
+//
+// # Options are used for the limiter of every key
+//
+// # IdleTTL is how long a key may go unused before it is dropped, zero keeps
+// keys forever
+type KeyedOptions struct {
+	Options
+	IdleTTL time.Duration
+}
+
+// KeyedRateLimiter keeps an independent RateLimiter per key, e.g. per user or
+// per client IP. Limiters are created on first use of a key. If IdleTTL is
+// set, a janitor goroutine periodically evicts idle keys until the context
+// is cancelled or Close is called.
+type KeyedRateLimiter[K comparable] struct {
+	ctx  context.Context
+	opts KeyedOptions
+
+	mu       sync.Mutex
+	limiters map[K]*keyedEntry
+	events   *chan Event
+
+	// closed is set by Close, after which no limiters are created anymore
+	// and every key gets closedRL, a closed limiter without a goroutine.
+	closed   bool
+	closedRL *RateLimiter
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type keyedEntry struct {
+	rl       *RateLimiter
+	lastUsed time.Time
+	waiting  int
+}
+
+func NewKeyedRateLimiter[K comparable](ctx context.Context, opts KeyedOptions) *KeyedRateLimiter[K] {
+	kl := &KeyedRateLimiter[K]{
+		ctx:      ctx,
+		opts:     opts,
+		limiters: make(map[K]*keyedEntry),
+		done:     make(chan struct{}),
+	}
+
+	if opts.IdleTTL > 0 {
+		go kl.janitor()
+	}
+
+	return kl
+}
+
+func (kl *KeyedRateLimiter[K]) janitor() {
+	ticker := time.NewTicker(max(kl.opts.IdleTTL/2, time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-kl.ctx.Done():
+			return
+		case <-kl.done:
+			return
+		case <-ticker.C:
+			kl.EvictIdle()
+		}
+	}
+}
+
+func (kl *KeyedRateLimiter[K]) Use(key K) bool {
+	return kl.get(key).Use()
+}
+
+func (kl *KeyedRateLimiter[K]) Wait(ctx context.Context, key K) error {
+	kl.mu.Lock()
+	if kl.closed {
+		kl.mu.Unlock()
+		return ErrClosed
+	}
+	e := kl.entry(key)
+	e.waiting++
+	kl.mu.Unlock()
+
+	defer func() {
+		kl.mu.Lock()
+		e.waiting--
+		e.lastUsed = time.Now()
+		kl.mu.Unlock()
+	}()
+
+	return e.rl.Wait(ctx)
+}
+
+// Limiter returns the limiter of key, creating it if needed, e.g. to read
+// its state after a call to Use. Like Use it counts as using the key. Once
+// the KeyedRateLimiter is closed it returns a closed limiter.
+func (kl *KeyedRateLimiter[K]) Limiter(key K) *RateLimiter {
+	return kl.get(key)
+}
+
+// Len returns the number of keys that currently have a limiter.
+func (kl *KeyedRateLimiter[K]) Len() int {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+
+	return len(kl.limiters)
+}
+
+// EvictIdle drops and closes the limiters of keys that have not been used
+// for longer than IdleTTL, returning how many were evicted. Keys with a Wait
+// in progress are never evicted. It does nothing if IdleTTL is zero.
+func (kl *KeyedRateLimiter[K]) EvictIdle() int {
+	if kl.opts.IdleTTL <= 0 {
+		return 0
+	}
+
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+
+	evicted := 0
+	now := time.Now()
+	for key, e := range kl.limiters {
+		if e.waiting > 0 || now.Sub(e.lastUsed) <= kl.opts.IdleTTL {
+			continue
+		}
+
+		e.rl.Close()
+		delete(kl.limiters, key)
+		evicted++
+	}
+
+	return evicted
+}
+
+// Events is like RateLimiter.Events for the limiters of all keys, with the
+// key of every call in Event.Key.
+func (kl *KeyedRateLimiter[K]) Events() <-chan Event {
+	return kl.EventsWithBuffer(DefaultEventBuffer)
+}
+
+// EventsWithBuffer is like RateLimiter.EventsWithBuffer for the limiters of
+// all keys.
+func (kl *KeyedRateLimiter[K]) EventsWithBuffer(size int) <-chan Event {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+
+	if kl.events == nil {
+		ch := make(chan Event, max(size, 0))
+		kl.events = &ch
+		for _, e := range kl.limiters {
+			e.rl.events.Store(kl.events)
+		}
+	}
+	return *kl.events
+}
+
+// Close stops the janitor and closes the limiter of every key. Afterwards
+// Use denies every key and Wait returns ErrClosed. Calling Close more than
+// once is safe.
+func (kl *KeyedRateLimiter[K]) Close() {
+	kl.closeOnce.Do(func() {
+		close(kl.done)
+
+		kl.mu.Lock()
+		defer kl.mu.Unlock()
+
+		kl.closed = true
+
+		for key, e := range kl.limiters {
+			e.rl.Close()
+			delete(kl.limiters, key)
+		}
+	})
+}
+
+func (kl *KeyedRateLimiter[K]) get(key K) *RateLimiter {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+
+	if kl.closed {
+		if kl.closedRL == nil {
+			kl.closedRL = newRateLimiter(kl.opts.Options, realClock{})
+			kl.closedRL.Close()
+		}
+		return kl.closedRL
+	}
+	return kl.entry(key).rl
+}
+
+// entry returns the entry for key, creating it if needed, and marks it as
+// used. The caller must hold kl.mu.
+func (kl *KeyedRateLimiter[K]) entry(key K) *keyedEntry {
+	e, ok := kl.limiters[key]
+	if !ok {
+		e = &keyedEntry{rl: NewRateLimiterWithBurst(kl.ctx, kl.opts.Options)}
+		e.rl.key = key
+		if kl.events != nil {
+			e.rl.events.Store(kl.events)
+		}
+		kl.limiters[key] = e
+	}
+
+	e.lastUsed = time.Now()
+	return e
+}
@@ -0,0 +1,90 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestKeyedEvictsIdleKeys(t *testing.T) {
+	check := checkGoroutines(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	kl := NewKeyedRateLimiter[string](ctx, KeyedOptions{
+		Options: Options{BurstAmount: 1, Interval: time.Millisecond},
+		IdleTTL: 500 * time.Millisecond,
+	})
+	defer kl.Close()
+
+	// IdleTTL leaves room to create the keys under the race detector.
+	for i := range 1000 {
+		kl.Use(fmt.Sprint(i))
+	}
+	if got := kl.Len(); got != 1000 {
+		t.Fatalf("Len after using 1000 keys = %d, want 1000", got)
+	}
+
+	// Every idle key is evicted along with the refill goroutine of its
+	// limiter, while a key used more often than IdleTTL stays.
+	deadline := time.Now().Add(2 * time.Second)
+	for kl.Len() > 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Len %d two seconds after the keys went idle, want 1", kl.Len())
+		}
+		kl.Use("busy")
+		time.Sleep(5 * time.Millisecond)
+	}
+	for range 150 {
+		kl.Use("busy")
+		time.Sleep(5 * time.Millisecond)
+	}
+	if kl.Len() != 1 {
+		t.Fatal("key in use was evicted")
+	}
+
+	kl.Close()
+	cancel()
+	check()
+}
+
+func TestKeyedJanitorStopsWithContext(t *testing.T) {
+	check := checkGoroutines(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	kl := NewKeyedRateLimiter[string](ctx, KeyedOptions{
+		Options: Options{BurstAmount: 1, Interval: time.Millisecond},
+		IdleTTL: time.Hour,
+	})
+	kl.Use("a")
+
+	// The janitor and the refill goroutine of the key stop with ctx.
+	cancel()
+	check()
+	if kl.Len() != 1 {
+		t.Fatal("cancelling ctx evicted a key")
+	}
+}
+
+func TestKeyedClosed(t *testing.T) {
+	check := checkGoroutines(t)
+
+	kl := NewKeyedRateLimiter[string](context.Background(), KeyedOptions{
+		Options: Options{BurstAmount: 1, Interval: time.Millisecond},
+	})
+	kl.Use("a")
+	kl.Close()
+
+	if kl.Use("b") {
+		t.Fatal("Use allowed after Close")
+	}
+	if err := kl.Wait(context.Background(), "b"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Wait after Close = %v, want ErrClosed", err)
+	}
+	if got := kl.Len(); got != 0 {
+		t.Fatalf("Len after Close = %d, want 0", got)
+	}
+	check()
+}
@@ -0,0 +1,132 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// LeakyBucket paces uses evenly, allowing at most one per rate with no
+// bursts. Up to capacity Wait calls may queue for a slot; further callers
+// are rejected with ErrQueueFull.
+type LeakyBucket struct {
+	mu sync.Mutex
+
+	rate     time.Duration
+	capacity int
+
+	next   time.Time
+	queued int
+
+	closed    bool
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewLeakyBucket(rate time.Duration, capacity int) *LeakyBucket {
+	if rate < 1 {
+		rate = time.Second
+	}
+	if capacity < 0 {
+		capacity = 0
+	}
+
+	return &LeakyBucket{
+		rate:     rate,
+		capacity: capacity,
+		next:     time.Now(),
+		done:     make(chan struct{}),
+	}
+}
+
+// Use succeeds if at least rate has passed since the last slot was handed
+// out and nobody is queued.
+func (lb *LeakyBucket) Use() bool {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	now := time.Now()
+	if lb.closed || lb.next.After(now) {
+		return false
+	}
+
+	lb.next = now.Add(lb.rate)
+	return true
+}
+
+// Wait queues for the next free slot and blocks until it is due. It returns
+// ErrQueueFull immediately if capacity callers are already queued, the
+// context error (see RateLimiter.Wait) if ctx is done before the slot, and
+// ErrClosed if the bucket is closed.
+func (lb *LeakyBucket) Wait(ctx context.Context) error {
+	lb.mu.Lock()
+	if lb.closed {
+		lb.mu.Unlock()
+		return ErrClosed
+	}
+	now := time.Now()
+	slot := lb.next
+	if !slot.After(now) {
+		lb.next = now.Add(lb.rate)
+		lb.mu.Unlock()
+		return nil
+	}
+	if lb.queued >= lb.capacity {
+		lb.mu.Unlock()
+		return ErrQueueFull
+	}
+	lb.next = slot.Add(lb.rate)
+	lb.queued++
+	lb.mu.Unlock()
+
+	timer := time.NewTimer(slot.Sub(now))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		lb.mu.Lock()
+		lb.queued--
+		lb.mu.Unlock()
+		return nil
+	case <-ctx.Done():
+		lb.mu.Lock()
+		lb.queued--
+		if lb.next.Equal(slot.Add(lb.rate)) {
+			// Nobody queued behind us, so the slot can be given back.
+			lb.next = slot
+		}
+		lb.mu.Unlock()
+		return ctxErr(ctx)
+	case <-lb.done:
+		lb.mu.Lock()
+		lb.queued--
+		lb.mu.Unlock()
+		return ErrClosed
+	}
+}
+
+// Tokens returns 1 when a Use would succeed right now. Otherwise it returns
+// how far the bucket is towards the next free slot, going negative when
+// callers are queued ahead.
+func (lb *LeakyBucket) Tokens() float64 {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	wait := time.Until(lb.next)
+	if wait <= 0 {
+		return 1
+	}
+	return 1 - float64(wait)/float64(lb.rate)
+}
+
+// Close makes every further Use fail and every Wait return ErrClosed.
+// Calling Close more than once is safe.
+func (lb *LeakyBucket) Close() {
+	lb.closeOnce.Do(func() {
+		lb.mu.Lock()
+		defer lb.mu.Unlock()
+
+		lb.closed = true
+		close(lb.done)
+	})
+}
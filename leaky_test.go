@@ -0,0 +1,65 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLeakyBucketSpacing(t *testing.T) {
+	const rate = 20 * time.Millisecond
+	lb := NewLeakyBucket(rate, 5)
+	defer lb.Close()
+
+	ctx := context.Background()
+	start := time.Now()
+	for i := range 5 {
+		if err := lb.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if elapsed, want := time.Since(start), time.Duration(i)*rate; elapsed < want {
+			t.Fatalf("Wait %d returned after %v, want its slot at %v", i, elapsed, want)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 4*rate+time.Second {
+		t.Fatalf("5 slots took %v, want about %v", elapsed, 4*rate)
+	}
+}
+
+func TestLeakyBucketQueueFull(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lb := NewLeakyBucket(time.Hour, 2)
+	defer lb.Close()
+	if !lb.Use() {
+		t.Fatal("Use on a fresh bucket failed")
+	}
+
+	errs := make(chan error, 2)
+	for range 2 {
+		go func() { errs <- lb.Wait(ctx) }()
+	}
+	eventually(t, func() bool {
+		lb.mu.Lock()
+		defer lb.mu.Unlock()
+
+		return lb.queued == 2
+	})
+
+	start := time.Now()
+	if err := lb.Wait(ctx); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Wait with a full queue = %v, want ErrQueueFull", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("Wait with a full queue blocked for %v", elapsed)
+	}
+
+	cancel()
+	for range 2 {
+		if err := <-errs; !errors.Is(err, context.Canceled) {
+			t.Fatalf("queued Wait after cancel = %v, want context.Canceled", err)
+		}
+	}
+}
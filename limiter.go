@@ -0,0 +1,22 @@
+package ratelimiter
+
+import "context"
+
+// Limiter is implemented by every rate limiting algorithm in this package,
+// so that code can depend on it and swap strategies freely.
+type Limiter interface {
+	Use() bool
+	Wait(ctx context.Context) error
+	Tokens() float64
+	Close()
+}
+
+var (
+	_ Limiter = (*RateLimiter)(nil)
+	_ Limiter = (*SlidingWindowLimiter)(nil)
+	_ Limiter = (*LeakyBucket)(nil)
+	_ Limiter = (*AdaptiveLimiter)(nil)
+	_ Limiter = (*MultiLimiter)(nil)
+	_ Limiter = (*ChildLimiter)(nil)
+	_ Limiter = (*ScheduledLimiter)(nil)
+)
@@ -0,0 +1,59 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimiterImplementations(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Every limiter allows a single use, then none for an hour.
+	one := Options{BurstAmount: 1, Interval: time.Hour}
+	limiters := map[string]func() Limiter{
+		"RateLimiter":          func() Limiter { return NewRateLimiterWithBurst(ctx, one) },
+		"SlidingWindowLimiter": func() Limiter { return NewSlidingWindowLimiter(1, time.Hour) },
+		"LeakyBucket":          func() Limiter { return NewLeakyBucket(time.Hour, 1) },
+		"AdaptiveLimiter": func() Limiter {
+			return NewAdaptiveLimiter(NewRateLimiterWithBurst(ctx, one), AdaptiveOptions{})
+		},
+		"MultiLimiter": func() Limiter { return NewMultiLimiter(NewRateLimiterWithBurst(ctx, one)) },
+		"ChildLimiter": func() Limiter {
+			return NewChildLimiter(ctx, NewRateLimiterWithBurst(ctx, Options{BurstAmount: 10, Interval: time.Hour}), one)
+		},
+		"ScheduledLimiter": func() Limiter { return NewScheduledLimiter(ctx, Schedule{Default: one}) },
+	}
+
+	for name, newLimiter := range limiters {
+		t.Run(name, func(t *testing.T) {
+			var l Limiter = newLimiter()
+
+			if !l.Use() {
+				t.Fatal("first Use failed")
+			}
+			if l.Use() {
+				t.Fatal("second Use succeeded past the limit")
+			}
+			if tokens := l.Tokens(); tokens >= 1 {
+				t.Fatalf("Tokens = %v after using up the limit, want less than 1", tokens)
+			}
+
+			waitCtx, waitCancel := context.WithTimeout(ctx, 20*time.Millisecond)
+			defer waitCancel()
+			if err := l.Wait(waitCtx); !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("Wait past the limit = %v, want context.DeadlineExceeded", err)
+			}
+
+			l.Close()
+			if l.Use() {
+				t.Fatal("Use succeeded after Close")
+			}
+			if err := l.Wait(ctx); !errors.Is(err, ErrClosed) {
+				t.Fatalf("Wait after Close = %v, want ErrClosed", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package ratelimiter
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+	"unsafe"
+)
+
+// WaitAll blocks until it can consume a token from every one of limiters at
+// once, e.g. a per-user and a global limit that must both allow a request.
+// It reserves a token from each limiter up front and waits for the latest of
+// them, so it holds its place in every limiter rather than retrying until
+// they all happen to allow at the same moment, which a steady stream of
+// other callers could keep from ever happening. Like Reserve, it queues
+// behind the Wait calls already blocked on a limiter. A caller that gives up
+// returns all its reserved tokens, so it never leaves tokens consumed from
+// some of them. It returns the context error if ctx is done first, or
+// ErrClosed if any of the limiters is closed.
+func WaitAll(ctx context.Context, limiters ...*RateLimiter) error {
+	limiters = lockOrder(limiters)
+	if len(limiters) == 0 {
+		return nil
+	}
+
+	for {
+		rs, err := reserveAll(limiters)
+		if err != nil {
+			return err
+		}
+
+		// Pause holds us up without anything telling us when it is over,
+		// so poll for it.
+		d := time.Millisecond
+		if rs != nil {
+			d = 0
+			for _, r := range rs {
+				d = max(d, r.Delay())
+			}
+			if d == 0 {
+				return nil
+			}
+		}
+
+		timer := limiters[0].clock.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			for _, r := range rs {
+				r.cancel(true)
+			}
+			return ctxErr(ctx)
+		case <-timer.C():
+		}
+		if rs == nil {
+			continue
+		}
+
+		// A limiter closed while we waited does not give its token.
+		for _, rl := range limiters {
+			rl.lock()
+			closed := rl.closed
+			rl.unlock()
+			if closed {
+				for _, r := range rs {
+					r.cancel(true)
+				}
+				return ErrClosed
+			}
+		}
+		return nil
+	}
+}
+
+// reserveAll reserves a token from each of limiters. If one of them is
+// paused it reserves nothing and returns a nil slice, as the reservations
+// would only be cancelled again, and if one is closed ErrClosed.
+func reserveAll(limiters []*RateLimiter) ([]*Reservation, error) {
+	for _, rl := range limiters {
+		rl.lock()
+		closed, paused := rl.closed, rl.paused
+		rl.unlock()
+		if closed {
+			return nil, ErrClosed
+		}
+		if paused {
+			return nil, nil
+		}
+	}
+
+	// A limiter may still be paused or closed meanwhile.
+	rs := make([]*Reservation, 0, len(limiters))
+	for _, rl := range limiters {
+		r := rl.Reserve()
+		if r.OK() {
+			rs = append(rs, r)
+			continue
+		}
+
+		for _, r := range rs {
+			r.cancel(true)
+		}
+		rl.lock()
+		closed := rl.closed
+		rl.unlock()
+		if closed {
+			return nil, ErrClosed
+		}
+		return nil, nil
+	}
+	return rs, nil
+}
+
+// takeAll consumes a token from each of limiters if every one of them can
+// give one right now, and otherwise returns how long to wait before trying
+// again. limiters must be in lock order.
+func takeAll(limiters []*RateLimiter) (time.Duration, error) {
+	for _, rl := range limiters {
+		rl.lock()
+		defer rl.unlock()
+	}
+
+	var wait time.Duration
+	nows := make([]time.Time, len(limiters))
+	for i, rl := range limiters {
+		if rl.closed {
+			return 0, ErrClosed
+		}
+
+		now := rl.clock.Now()
+		nows[i] = now
+		rl.refill(now)
+		at := rl.burstCooldown
+		if due := rl.due(1, now); due.After(at) {
+			at = due
+		}
+		if d := at.Sub(now); d > 0 || rl.burst < 1 || len(rl.queue) > 0 || rl.paused {
+			// A tick may be late past its due time, and queued waiters and
+			// Pause hold us up without anything telling us when they are
+			// done, so poll for those.
+			wait = max(wait, d, time.Millisecond)
+		}
+	}
+	if wait > 0 {
+		return wait, nil
+	}
+
+	// Every limiter was just checked at the same time, so none can refuse.
+	for i, rl := range limiters {
+		rl.takeLocked(1, nows[i])
+	}
+	return 0, nil
+}
+
+// lockOrder returns limiters without duplicates, sorted by address, which
+// is the order their locks are taken in by everything that locks more than
+// one limiter, so that two such calls cannot deadlock.
+func lockOrder(limiters []*RateLimiter) []*RateLimiter {
+	limiters = slices.Clone(limiters)
+	slices.SortFunc(limiters, func(a, b *RateLimiter) int {
+		return cmp.Compare(uintptr(unsafe.Pointer(a)), uintptr(unsafe.Pointer(b)))
+	})
+	return slices.Compact(limiters)
+}
+
+// MultiLimiter combines limiters with OR semantics, e.g. to give tiered
+// quotas such as a burst pool backed by a sustained one. Limiters are tried
+// in the order they were given, and a token is consumed from the first one
+// that allows, so earlier limiters are always preferred over later ones.
+type MultiLimiter struct {
+	limiters []Limiter
+}
+
+func NewMultiLimiter(limiters ...Limiter) *MultiLimiter {
+	return &MultiLimiter{limiters: slices.Clone(limiters)}
+}
+
+// Use consumes a token from the first limiter that allows it, and returns
+// false if none does.
+func (ml *MultiLimiter) Use() bool {
+	for _, l := range ml.limiters {
+		if l.Use() {
+			return true
+		}
+	}
+	return false
+}
+
+// Wait is like Use but, if no limiter allows right now, waits for a token
+// from the last one, the fallback of the tiers. It returns ErrClosed if
+// there are no limiters.
+func (ml *MultiLimiter) Wait(ctx context.Context) error {
+	if len(ml.limiters) == 0 {
+		return ErrClosed
+	}
+	if ml.Use() {
+		return nil
+	}
+	return ml.limiters[len(ml.limiters)-1].Wait(ctx)
+}
+
+// Tokens returns the tokens available across all limiters.
+func (ml *MultiLimiter) Tokens() float64 {
+	var tokens float64
+	for _, l := range ml.limiters {
+		tokens += l.Tokens()
+	}
+	return tokens
+}
+
+// Close closes every limiter.
+func (ml *MultiLimiter) Close() {
+	for _, l := range ml.limiters {
+		l.Close()
+	}
+}
@@ -0,0 +1,186 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWaitAllPausedLeavesCooldown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	paused := NewRateLimiterWithBurst(ctx, Options{BurstAmount: 1, Interval: time.Hour})
+	paused.Pause()
+	other := NewRateLimiterWithBurst(ctx, Options{BurstAmount: 5, Interval: time.Hour, BurstInterval: 50 * time.Millisecond})
+
+	timeout, stop := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer stop()
+	if err := WaitAll(timeout, paused, other); !errors.Is(err, ErrWaitTimeout) {
+		t.Fatalf("WaitAll with a paused limiter = %v, want ErrWaitTimeout", err)
+	}
+
+	if d := other.TimeToNext(); d != 0 {
+		t.Fatalf("TimeToNext of the other limiter = %v, want 0", d)
+	}
+	if got := other.CurrentBurst(); got != 5 {
+		t.Fatalf("CurrentBurst of the other limiter = %d, want 5", got)
+	}
+}
+
+func TestReserveAllPausedReservesNothing(t *testing.T) {
+	clk := newFakeClock()
+	other := newFakeLazyLimiter(Options{BurstAmount: 5, Interval: time.Hour, BurstInterval: 50 * time.Millisecond}, clk)
+	paused := newFakeLazyLimiter(Options{BurstAmount: 1, Interval: time.Hour}, clk)
+	paused.Pause()
+
+	// Polling for the pause to end must not move the cooldown of the
+	// limiters reserved from before the paused one.
+	for range 10 {
+		if rs, err := reserveAll([]*RateLimiter{other, paused}); rs != nil || err != nil {
+			t.Fatalf("reserveAll with a paused limiter = %v, %v, want nil, nil", rs, err)
+		}
+		clk.Advance(time.Millisecond)
+	}
+	if d := other.TimeToNext(); d != 0 {
+		t.Fatalf("TimeToNext of the other limiter = %v, want 0", d)
+	}
+}
+
+func TestWaitAllConcurrent(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	type limit struct {
+		opts Options
+		uses int
+		rl   *RateLimiter
+	}
+	limits := []*limit{
+		{opts: Options{BurstAmount: 3, Interval: time.Millisecond}},
+		{opts: Options{BurstAmount: 2, Interval: 2 * time.Millisecond}},
+		{opts: Options{BurstAmount: 4, Interval: 3 * time.Millisecond}},
+	}
+	for _, l := range limits {
+		l.rl = NewRateLimiterWithBurst(ctx, l.opts)
+	}
+	a, b, c := limits[0], limits[1], limits[2]
+	sets := [][]*limit{{a, b, c}, {a, b}, {b, c}, {c, a}}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 10 {
+				set := sets[(i+j)%len(sets)]
+				limiters := make([]*RateLimiter, len(set))
+				for k, l := range set {
+					limiters[k] = l.rl
+				}
+				if err := WaitAll(ctx, limiters...); err != nil {
+					t.Errorf("WaitAll: %v", err)
+					return
+				}
+				mu.Lock()
+				for _, l := range set {
+					l.uses++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	// Every caller got through, and no limiter let through more than its
+	// burst plus what refilled meanwhile.
+	for i, l := range limits {
+		if most := l.opts.BurstAmount + int(elapsed/l.opts.Interval) + 1; l.uses > most {
+			t.Errorf("limiter %d let %d uses through in %v, want at most %d", i, l.uses, elapsed, most)
+		}
+	}
+}
+
+func TestWaitAllExhaustedTakesNothing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	exhausted := NewRateLimiterWithBurst(ctx, Options{BurstAmount: 1, Interval: time.Hour})
+	exhausted.Drain()
+	other := NewRateLimiterWithBurst(ctx, Options{BurstAmount: 3, Interval: time.Hour})
+
+	timeout, stop := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer stop()
+	if err := WaitAll(timeout, exhausted, other); !errors.Is(err, ErrWaitTimeout) {
+		t.Fatalf("WaitAll with an exhausted limiter = %v, want ErrWaitTimeout", err)
+	}
+	if got := other.CurrentBurst(); got != 3 {
+		t.Fatalf("CurrentBurst of the other limiter = %d, want 3", got)
+	}
+	if got := exhausted.CurrentBurst(); got != 0 {
+		t.Fatalf("CurrentBurst of the exhausted limiter = %d, want 0", got)
+	}
+}
+
+func TestWaitAllClosedWhileWaiting(t *testing.T) {
+	clk := newFakeClock()
+	slow := newFakeLazyLimiter(Options{BurstAmount: 1, Interval: 50 * time.Millisecond}, clk)
+	slow.Drain()
+	closing := newFakeLazyLimiter(Options{BurstAmount: 1, Interval: time.Hour}, clk)
+
+	errs := make(chan error, 1)
+	go func() { errs <- WaitAll(context.Background(), slow, closing) }()
+	awaitTimer(t, clk, clk.Now().Add(50*time.Millisecond))
+	closing.Close()
+	clk.Advance(50 * time.Millisecond)
+
+	if err := <-errs; !errors.Is(err, ErrClosed) {
+		t.Fatalf("WaitAll with a limiter closed while waiting = %v, want ErrClosed", err)
+	}
+	// The token reserved from the limiter still open was given back, on top
+	// of the one that refilled meanwhile to repay it.
+	if !slow.Use() {
+		t.Fatal("WaitAll kept the token of the limiter still open")
+	}
+}
+
+func TestMultiLimiterFallsBack(t *testing.T) {
+	clk := newFakeClock()
+	first := newFakeLazyLimiter(Options{BurstAmount: 1, Interval: time.Hour}, clk)
+	second := newFakeLazyLimiter(Options{BurstAmount: 2, Interval: time.Hour}, clk)
+	ml := NewMultiLimiter(first, second)
+
+	if !ml.Use() {
+		t.Fatal("first Use failed")
+	}
+	if first.CurrentBurst() != 0 || second.CurrentBurst() != 2 {
+		t.Fatalf("first Use took from the second limiter: CurrentBurst %d and %d, want 0 and 2", first.CurrentBurst(), second.CurrentBurst())
+	}
+
+	// The first limiter is exhausted, so the second one still allows.
+	for i := range 2 {
+		if !ml.Use() {
+			t.Fatalf("Use %d with the second limiter available failed", i)
+		}
+	}
+	if second.CurrentBurst() != 0 {
+		t.Fatalf("CurrentBurst of the second limiter = %d, want 0", second.CurrentBurst())
+	}
+	if ml.Use() {
+		t.Fatal("Use with both limiters exhausted succeeded")
+	}
+
+	// A refill of the first limiter makes it preferred again.
+	clk.Advance(time.Hour)
+	if !ml.Use() {
+		t.Fatal("Use after a refill failed")
+	}
+	if first.CurrentBurst() != 0 || second.CurrentBurst() != 1 {
+		t.Fatalf("Use after a refill: CurrentBurst %d and %d, want 0 and 1", first.CurrentBurst(), second.CurrentBurst())
+	}
+}
@@ -0,0 +1,120 @@
+package ratelimiter
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Option configures a limiter built by New.
+type Option func(*config)
+
+// config is what the Options passed to New mutate, before it is turned into
+// a limiter.
+type config struct {
+	Options
+	rand rand.Source
+
+	agingStep     int
+	agingInterval time.Duration
+}
+
+// New returns a limiter configured by opts. Omitted options default to a
+// burst of 1, an interval of one second, no burst interval and no jitter.
+func New(ctx context.Context, opts ...Option) *RateLimiter {
+	cfg := config{
+		Options: Options{
+			BurstAmount: 1,
+			Interval:    time.Second,
+		},
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	rl := newRateLimiter(cfg.Options, realClock{})
+	if cfg.rand != nil {
+		rl.rand = rand.New(cfg.rand)
+	}
+	if cfg.agingStep > 0 && cfg.agingInterval > 0 {
+		rl.agingStep = cfg.agingStep
+		rl.agingInterval = cfg.agingInterval
+	}
+	rl.start(ctx)
+	return rl
+}
+
+// WithBurst sets the amount of uses that can be used in a burst.
+func WithBurst(n int) Option {
+	return func(c *config) {
+		c.BurstAmount = n
+	}
+}
+
+// WithInterval sets the time to wait for the burst to refill by one.
+func WithInterval(d time.Duration) Option {
+	return func(c *config) {
+		c.Interval = d
+	}
+}
+
+// WithBurstInterval sets the minimum time between each use in a burst.
+func WithBurstInterval(d time.Duration) Option {
+	return func(c *config) {
+		c.BurstInterval = d
+	}
+}
+
+// WithJitter sets the fraction of the interval by which refills are
+// randomized.
+func WithJitter(jitter float64) Option {
+	return func(c *config) {
+		c.Jitter = jitter
+	}
+}
+
+// WithInitialBurst sets the number of tokens the limiter starts with, see
+// Options.InitialBurst.
+func WithInitialBurst(n int) Option {
+	return func(c *config) {
+		c.InitialBurst = &n
+	}
+}
+
+// WithDisabled makes the limiter a pass-through if disabled is true, see
+// Options.Disabled.
+func WithDisabled(disabled bool) Option {
+	return func(c *config) {
+		c.Disabled = disabled
+	}
+}
+
+// WithAllowOversizedRequests lets WaitN ask for more tokens than the burst if
+// allow is true, see Options.AllowOversizedRequests.
+func WithAllowOversizedRequests(allow bool) Option {
+	return func(c *config) {
+		c.AllowOversizedRequests = allow
+	}
+}
+
+// WithAging makes blocked WaitPriority calls gain step priority for every
+// interval they have waited, so that low priority callers are not starved
+// indefinitely by a steady stream of higher priority ones. A step or
+// interval <= 0 disables aging, which is the default.
+func WithAging(step int, interval time.Duration) Option {
+	return func(c *config) {
+		c.agingStep = step
+		c.agingInterval = interval
+	}
+}
+
+// WithRandSource sets the source of all randomness of the limiter, such as
+// the refill jitter and WaitWithJitter, instead of one seeded from the
+// current time. Limiters given sources with the same seed make the same
+// random choices, which makes them reproducible in tests and when debugging.
+// The limiter takes ownership of src, which must not be used elsewhere.
+func WithRandSource(src rand.Source) Option {
+	return func(c *config) {
+		c.rand = src
+	}
+}
@@ -0,0 +1,89 @@
+package ratelimiter
+
+import (
+	"context"
+	"math/rand"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestWithRandSource(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	jitters := func(seed int64) []time.Duration {
+		rl := New(ctx, WithInterval(time.Second), WithJitter(0.5), WithRandSource(rand.NewSource(seed)))
+		defer rl.Close()
+
+		rl.mu.Lock()
+		defer rl.mu.Unlock()
+
+		var intervals []time.Duration
+		for range 20 {
+			intervals = append(intervals, rl.nextInterval())
+		}
+		return intervals
+	}
+
+	a, b := jitters(42), jitters(42)
+	if !slices.Equal(a, b) {
+		t.Fatalf("limiters seeded alike jittered differently:\n%v\n%v", a, b)
+	}
+	if c := jitters(43); slices.Equal(a, c) {
+		t.Fatalf("limiters seeded differently jittered alike: %v", a)
+	}
+}
+
+func TestNew(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rl := New(ctx)
+	defer rl.Close()
+	want := Options{BurstAmount: 1, Interval: time.Second, RefillAmount: 1}
+	if got := rl.options(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("New without options = %+v, want the defaults %+v", got, want)
+	}
+	if rl.agingStep != 0 {
+		t.Fatalf("New without options ages waiters by %d", rl.agingStep)
+	}
+
+	rl = New(ctx,
+		WithBurst(10),
+		WithInterval(time.Minute),
+		WithBurstInterval(100*time.Millisecond),
+		WithJitter(0.1),
+		WithInitialBurst(3),
+		WithAllowOversizedRequests(true),
+		WithAging(2, time.Second),
+	)
+	defer rl.Close()
+	// InitialBurst only decides the tokens the limiter starts with.
+	want = Options{
+		BurstAmount:            10,
+		Interval:               time.Minute,
+		BurstInterval:          100 * time.Millisecond,
+		RefillAmount:           1,
+		Jitter:                 0.1,
+		AllowOversizedRequests: true,
+	}
+	if got := rl.options(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("New with options = %+v, want %+v", got, want)
+	}
+	if got := rl.CurrentBurst(); got != 3 {
+		t.Fatalf("CurrentBurst with WithInitialBurst(3) = %d, want 3", got)
+	}
+	if rl.agingStep != 2 || rl.agingInterval != time.Second {
+		t.Fatalf("aging = %d per %v, want 2 per 1s", rl.agingStep, rl.agingInterval)
+	}
+
+	disabled := New(ctx, WithDisabled(true))
+	defer disabled.Close()
+	for range 5 {
+		if !disabled.Use() {
+			t.Fatal("Use on a limiter built WithDisabled(true) failed")
+		}
+	}
+}
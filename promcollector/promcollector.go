@@ -0,0 +1,46 @@
+// Package promcollector exports ratelimiter.RateLimiter statistics as
+// Prometheus metrics. It lives in its own module so that the core
+// ratelimiter module does not depend on the Prometheus client.
+package promcollector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/joohnes/ratelimiter"
+)
+
+type collector struct {
+	rl *ratelimiter.RateLimiter
+
+	currentBurst *prometheus.Desc
+	maxBurst     *prometheus.Desc
+	allowed      *prometheus.Desc
+	denied       *prometheus.Desc
+}
+
+// NewCollector returns a collector exporting the Stats of rl. name is used
+// as a prefix of every metric, e.g. "api" exports api_current_burst.
+func NewCollector(rl *ratelimiter.RateLimiter, name string) prometheus.Collector {
+	return &collector{
+		rl:           rl,
+		currentBurst: prometheus.NewDesc(name+"_current_burst", "Tokens currently available.", nil, nil),
+		maxBurst:     prometheus.NewDesc(name+"_max_burst", "Maximum number of tokens.", nil, nil),
+		allowed:      prometheus.NewDesc(name+"_allowed_total", "Uses that were allowed.", nil, nil),
+		denied:       prometheus.NewDesc(name+"_denied_total", "Uses that were denied.", nil, nil),
+	}
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.currentBurst
+	ch <- c.maxBurst
+	ch <- c.allowed
+	ch <- c.denied
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.rl.Stats()
+	ch <- prometheus.MustNewConstMetric(c.currentBurst, prometheus.GaugeValue, float64(stats.CurrentBurst))
+	ch <- prometheus.MustNewConstMetric(c.maxBurst, prometheus.GaugeValue, float64(stats.MaxBurst))
+	ch <- prometheus.MustNewConstMetric(c.allowed, prometheus.CounterValue, float64(stats.Allowed))
+	ch <- prometheus.MustNewConstMetric(c.denied, prometheus.CounterValue, float64(stats.Denied))
+}
@@ -0,0 +1,36 @@
+package promcollector
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/joohnes/ratelimiter"
+)
+
+func TestCollector(t *testing.T) {
+	rl := ratelimiter.NewLazyRateLimiter(ratelimiter.Options{BurstAmount: 3, Interval: time.Hour})
+	for range 4 {
+		rl.Use()
+	}
+
+	const want = `
+# HELP api_allowed_total Uses that were allowed.
+# TYPE api_allowed_total counter
+api_allowed_total 3
+# HELP api_current_burst Tokens currently available.
+# TYPE api_current_burst gauge
+api_current_burst 0
+# HELP api_denied_total Uses that were denied.
+# TYPE api_denied_total counter
+api_denied_total 1
+# HELP api_max_burst Maximum number of tokens.
+# TYPE api_max_burst gauge
+api_max_burst 3
+`
+	if err := testutil.CollectAndCompare(NewCollector(rl, "api"), strings.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+}
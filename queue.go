@@ -0,0 +1,277 @@
+package ratelimiter
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+)
+
+// waiter is a blocked Wait call queued for tokens. ready is signalled when
+// the waiter should re-check, typically because it reached the head of the
+// queue or tokens were added. While at the head, the waiter collects tokens
+// into held as they are refilled, until it has all it asked for, n. With
+// aging, priority rises from base with the time since the waiter was queued.
+// behindAt is the limiter's behind when the waiter was queued, so that it
+// only gets ahead of reservations made after it.
+type waiter struct {
+	n        int
+	priority int
+	base     int
+	since    time.Time
+	ready    chan struct{}
+	held     float64
+	behindAt float64
+}
+
+// WaitPriority is like Wait, but when several callers are blocked the tokens
+// go to the highest priority first, and to the earliest caller among equal
+// priorities. Wait and WaitN queue with priority 0, so they are served in
+// arrival order. On a limiter built WithAging, the priority of a blocked
+// caller rises the longer it waits, so that it is eventually served even
+// under a steady stream of higher priority callers.
+func (rl *RateLimiter) WaitPriority(ctx context.Context, priority int) error {
+	return rl.wait(ctx, 1, priority, noQueueLimit)
+}
+
+// TryWaitN is like WaitN but sheds load under overload: if the caller would
+// have to queue at a position beyond maxQueuePos, counting the head of the
+// queue as position 1, it returns ErrQueueFull straight away instead of
+// blocking. maxQueuePos < 1 only lets callers through that need not queue.
+func (rl *RateLimiter) TryWaitN(ctx context.Context, n, maxQueuePos int) error {
+	return rl.wait(ctx, n, 0, max(maxQueuePos, 0))
+}
+
+// noQueueLimit lets a wait queue behind any number of waiters.
+const noQueueLimit = -1
+
+func (rl *RateLimiter) wait(ctx context.Context, n, priority, maxQueuePos int) error {
+	start := rl.clock.Now()
+	ok, _, err := rl.take(n, start)
+	if ok || err != nil && err != ErrExceedsBurst {
+		return err
+	}
+
+	rl.lock()
+	if err != nil && !rl.allowOversized {
+		rl.unlock()
+		return err
+	}
+	rl.age(start)
+	if maxQueuePos != noQueueLimit && rl.position(priority)+1 > maxQueuePos {
+		rl.unlock()
+		return ErrQueueFull
+	}
+
+	rl.waiters.Add(1)
+	defer rl.waiters.Add(-1)
+	defer rl.recordWait(start)
+
+	w := rl.enqueue(n, priority, start)
+	for {
+		var cooldown time.Duration
+		now := rl.clock.Now()
+		rl.age(now)
+		if rl.queue[0] == w {
+			ok, cooldown, err = rl.collect(w, n, now)
+			if ok || err != nil {
+				rl.release(w)
+				rl.dequeue(w)
+				rl.unlock()
+				if ok && cooldown > 0 {
+					return rl.repay(ctx, n, cooldown)
+				}
+				return err
+			}
+		}
+		rl.unlock()
+
+		if err := rl.sleep(ctx, cooldown, w.ready); err != nil {
+			rl.lock()
+			rl.release(w)
+			rl.dequeue(w)
+			rl.unlock()
+			return err
+		}
+		rl.lock()
+	}
+}
+
+// collect moves available tokens into the held tokens of w, the head of the
+// queue, and consumes them once w holds n and the burst cooldown has
+// elapsed. Otherwise it returns how long w should wait before collecting
+// again, like takeLocked. An oversized request is consumed once w holds a
+// full bucket, leaving the bucket in debt for the rest, and collect then
+// returns how long until the debt is repaid. The caller must hold rl.mu.
+func (rl *RateLimiter) collect(w *waiter, n int, now time.Time) (bool, time.Duration, error) {
+	if n < 1 {
+		n = 1
+	}
+	if rl.closed {
+		return false, 0, ErrClosed
+	}
+	if n > rl.maxBurst && !rl.allowOversized {
+		return false, 0, ErrExceedsBurst
+	}
+	if rl.paused {
+		return false, 0, nil
+	}
+
+	rl.refill(now)
+	need := float64(min(n, rl.maxBurst))
+	if missing := need - w.held; missing > 0 && rl.available(w) > 0 {
+		moved := min(rl.available(w), missing)
+		rl.burst -= moved
+		rl.held += moved
+		w.held += moved
+	}
+
+	if missing := need - w.held; missing > 0 {
+		if rl.lazy {
+			return false, rl.due(missing-rl.passed(w), now).Sub(now), nil
+		}
+		return false, 0, nil
+	}
+	if rl.burstCooldown.After(now) {
+		return false, rl.burstCooldown.Sub(now), nil
+	}
+
+	debt := float64(n) - w.held
+	rl.held -= w.held
+	rl.burst -= debt
+	w.held = 0
+	rl.wakeTicker(now)
+	rl.burstCooldown = now.Add(rl.burstInterval)
+	rl.allowed.Add(1)
+	if debt > 0 {
+		// Reservations made since w was queued are repaid after it.
+		return true, max(rl.due(-rl.passed(w), now).Sub(now), 0), nil
+	}
+	return true, 0, nil
+}
+
+// repay blocks an oversized request that was just granted until the debt it
+// left the bucket in is repaid, the remainder of its tokens having refilled.
+// If ctx is done first the tokens are given back, as the caller will not use
+// them.
+func (rl *RateLimiter) repay(ctx context.Context, n int, d time.Duration) error {
+	if err := rl.sleep(ctx, d, nil); err != nil {
+		rl.Refund(n)
+		return err
+	}
+	return nil
+}
+
+// release returns the tokens held by w to the bucket, so that a cancelled
+// or displaced waiter does not starve the others. The caller must hold
+// rl.mu.
+func (rl *RateLimiter) release(w *waiter) {
+	if w.held == 0 {
+		return
+	}
+
+	// The burst may have been lowered below what w holds meanwhile.
+	rl.held -= w.held
+	rl.burst = min(rl.burst+w.held, rl.capacity())
+	w.held = 0
+}
+
+// position returns the index in the queue a new waiter of the given
+// priority would be inserted at. The caller must hold rl.mu and, with aging,
+// have aged the queue up to the time the waiter is queued at.
+func (rl *RateLimiter) position(priority int) int {
+	i := 0
+	for i < len(rl.queue) && rl.queue[i].priority >= priority {
+		i++
+	}
+	return i
+}
+
+// available returns the tokens w may collect: those in the bucket, plus
+// those taken by reservations made since w was queued, which are only due
+// once w has been served. The caller must hold rl.mu.
+func (rl *RateLimiter) available(w *waiter) float64 {
+	return rl.burst + rl.passed(w)
+}
+
+// passed returns the tokens taken by reservations made since w was queued.
+// The caller must hold rl.mu.
+func (rl *RateLimiter) passed(w *waiter) float64 {
+	return max(rl.behind-w.behindAt, 0)
+}
+
+// outstanding returns the tokens the queued waiters still need. The caller
+// must hold rl.mu.
+func (rl *RateLimiter) outstanding() float64 {
+	var need float64
+	for _, w := range rl.queue {
+		need += float64(min(w.n, rl.maxBurst)) - w.held
+	}
+	return need
+}
+
+// enqueue adds a waiter for n tokens queued at now behind every waiter of the
+// same or a higher priority. The caller must hold rl.mu.
+func (rl *RateLimiter) enqueue(n, priority int, now time.Time) *waiter {
+	w := &waiter{
+		n:        max(n, 1),
+		priority: priority,
+		base:     priority,
+		since:    now,
+		ready:    make(chan struct{}, 1),
+		behindAt: rl.behind,
+	}
+
+	i := rl.position(priority)
+	if i == 0 && len(rl.queue) > 0 {
+		// The new waiter takes over the head, and with it the tokens
+		// collected so far.
+		rl.release(rl.queue[0])
+	}
+	rl.queue = slices.Insert(rl.queue, i, w)
+	return w
+}
+
+// age raises the priority of every waiter by agingStep for every
+// agingInterval it has been queued as of now, and reorders the queue to
+// match, keeping the earliest caller first among equal priorities. A waiter
+// that ages past the head takes over from it, like a new waiter of a higher
+// priority would. The caller must hold rl.mu.
+func (rl *RateLimiter) age(now time.Time) {
+	if rl.agingStep == 0 || len(rl.queue) == 0 {
+		return
+	}
+
+	for _, w := range rl.queue {
+		w.priority = w.base + rl.agingStep*int(max(now.Sub(w.since), 0)/rl.agingInterval)
+	}
+
+	head := rl.queue[0]
+	slices.SortStableFunc(rl.queue, func(a, b *waiter) int {
+		if c := cmp.Compare(b.priority, a.priority); c != 0 {
+			return c
+		}
+		return a.since.Compare(b.since)
+	})
+	if rl.queue[0] != head {
+		rl.release(head)
+		rl.notify()
+	}
+}
+
+// dequeue removes w from the queue and wakes the new head, so that it takes
+// over. The caller must hold rl.mu.
+//
+// A ticker-driven limiter wakes the head on every tick, so with the bucket
+// empty the new head is left asleep until the next one. Together with only
+// ever waking the head, a refill of k tokens then wakes the k waiters it
+// serves one after another, rather than every blocked waiter rushing for
+// the lock at once.
+func (rl *RateLimiter) dequeue(w *waiter) {
+	if i := slices.Index(rl.queue, w); i >= 0 {
+		rl.queue = slices.Delete(rl.queue, i, i+1)
+	}
+	if len(rl.queue) > 0 && (rl.lazy || rl.available(rl.queue[0]) > 0) {
+		rl.notify()
+	}
+}
@@ -0,0 +1,306 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startWaiters starts a WaitN call for every n in ns, one after another so
+// that they queue in that order, and returns a channel receiving the index
+// of every call as it returns.
+func startWaiters(t *testing.T, ctx context.Context, rl *RateLimiter, ns ...int) <-chan int {
+	t.Helper()
+
+	done := make(chan int, len(ns))
+	for i, n := range ns {
+		go func() {
+			if err := rl.WaitN(ctx, n); err != nil {
+				t.Errorf("WaitN %d: %v", i, err)
+			}
+			done <- i
+		}()
+		eventually(t, func() bool { return rl.Stats().WaitersBlocked == i+1 })
+	}
+	return done
+}
+
+func TestWaitFIFO(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	rl := newFakeLimiter(ctx, Options{BurstAmount: 1, Interval: 10 * time.Millisecond}, clk)
+	rl.Drain()
+
+	done := startWaiters(t, ctx, rl, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1)
+	for want := range 10 {
+		clk.Advance(10 * time.Millisecond)
+		if got := <-done; got != want {
+			t.Fatalf("waiter %d unblocked in place of waiter %d", got, want)
+		}
+	}
+}
+
+func TestUseDoesNotBargeWaiters(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	rl := newFakeLimiter(ctx, Options{BurstAmount: 2, Interval: 10 * time.Millisecond}, clk)
+	rl.Drain()
+
+	done := startWaiters(t, ctx, rl, 2)
+	clk.Advance(10 * time.Millisecond)
+	if rl.Use() {
+		t.Fatal("Use took a token collected by a queued waiter")
+	}
+	clk.Advance(10 * time.Millisecond)
+	<-done
+}
+
+func TestWaitPriorityAging(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	rl := newRateLimiter(Options{BurstAmount: 1, Interval: 10 * time.Millisecond}, clk)
+	rl.agingStep, rl.agingInterval = 1, 10*time.Millisecond
+	rl.start(ctx)
+	rl.Drain()
+
+	low := make(chan struct{})
+	go func() {
+		rl.WaitPriority(ctx, 0)
+		close(low)
+	}()
+	eventually(t, func() bool { return rl.Stats().WaitersBlocked == 1 })
+
+	// A steady stream of priority 3 callers, two of them queued at any
+	// time, is overtaken once the low priority caller has aged past them.
+	served := make(chan struct{}, 100)
+	high := func() {
+		if rl.WaitPriority(ctx, 3) == nil {
+			served <- struct{}{}
+		}
+	}
+	go high()
+	go high()
+	eventually(t, func() bool { return rl.Stats().WaitersBlocked == 3 })
+
+	for i := 0; ; i++ {
+		if i == 20 {
+			t.Fatal("low priority waiter starved despite aging")
+		}
+		clk.Advance(10 * time.Millisecond)
+		select {
+		case <-low:
+			return
+		case <-served:
+			go high()
+			eventually(t, func() bool { return rl.Stats().WaitersBlocked == 3 })
+		case <-time.After(time.Second):
+			t.Fatal("nobody was served by a refill")
+		}
+	}
+}
+
+func TestQueueConcurrentWithReservations(t *testing.T) {
+	for _, lazy := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		opts := Options{BurstAmount: 3, Interval: 100 * time.Microsecond}
+		var rl *RateLimiter
+		if lazy {
+			rl = NewLazyRateLimiter(opts)
+		} else {
+			rl = NewRateLimiterWithBurst(ctx, opts)
+		}
+
+		var wg sync.WaitGroup
+		for i := range 12 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := range 50 {
+					switch i % 4 {
+					case 0:
+						rl.Use()
+					case 1:
+						if r := rl.Reserve(); j%2 == 0 {
+							r.Cancel()
+						}
+					default:
+						waitCtx, stop := context.WithTimeout(ctx, time.Millisecond)
+						rl.WaitN(waitCtx, i%3+1)
+						stop()
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		// Everything that gave up left the queue and returned what it held.
+		rl.mu.Lock()
+		queued, held := len(rl.queue), rl.held
+		rl.mu.Unlock()
+		if queued != 0 || held != 0 {
+			t.Fatalf("lazy=%v: %d waiters queued holding %g tokens after all returned", lazy, queued, held)
+		}
+		if err := rl.Wait(ctx); err != nil {
+			t.Fatalf("lazy=%v: Wait after the others returned: %v", lazy, err)
+		}
+		cancel()
+	}
+}
+
+func TestSetBurstBelowHeldTokens(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	rl := newFakeLimiter(ctx, Options{BurstAmount: 4, Interval: 10 * time.Millisecond}, clk)
+	rl.Drain()
+
+	errs := make(chan error, 1)
+	go func() { errs <- rl.WaitN(ctx, 4) }()
+	eventually(t, func() bool { return rl.Stats().WaitersBlocked == 1 })
+	clk.Advance(20 * time.Millisecond)
+	eventually(t, func() bool {
+		rl.mu.Lock()
+		defer rl.mu.Unlock()
+		return rl.held == 2
+	})
+
+	// The waiter holds two tokens, as much as the new burst allows, so no
+	// refill would ever wake it.
+	rl.SetBurst(2)
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrExceedsBurst) {
+			t.Fatalf("WaitN(4) after SetBurst(2) = %v, want ErrExceedsBurst", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter not woken by SetBurst lowering the burst below its tokens")
+	}
+
+	if got := rl.CurrentBurst(); got != 2 {
+		t.Fatalf("CurrentBurst after the waiter gave up = %d, want 2", got)
+	}
+	if !rl.CanUse() || !rl.Use() {
+		t.Fatal("limiter still locked up after the waiter gave up")
+	}
+}
+
+func TestTryWaitNQueueFull(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	rl := newFakeLimiter(ctx, Options{BurstAmount: 3, Interval: 10 * time.Millisecond}, clk)
+
+	// Callers that need not queue pass even with no room in the queue.
+	if err := rl.TryWaitN(ctx, 1, 0); err != nil {
+		t.Fatalf("TryWaitN with a token available = %v, want nil", err)
+	}
+	rl.Drain()
+
+	done := startWaiters(t, ctx, rl, 1, 1, 1)
+	start := time.Now()
+	if err := rl.TryWaitN(ctx, 1, 3); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("TryWaitN at position 4 of 3 = %v, want ErrQueueFull", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("TryWaitN with a full queue blocked for %v", elapsed)
+	}
+	if got := rl.Stats().WaitersBlocked; got != 3 {
+		t.Fatalf("WaitersBlocked after the rejection = %d, want 3", got)
+	}
+
+	// One more place in the queue lets the caller wait its turn.
+	errs := make(chan error, 1)
+	go func() { errs <- rl.TryWaitN(ctx, 1, 4) }()
+	eventually(t, func() bool { return rl.Stats().WaitersBlocked == 4 })
+	for range 3 {
+		clk.Advance(10 * time.Millisecond)
+		<-done
+	}
+	clk.Advance(10 * time.Millisecond)
+	if err := <-errs; err != nil {
+		t.Fatalf("TryWaitN at position 4 of 4 = %v, want nil", err)
+	}
+}
+
+// BenchmarkWaitWakeups measures a refill of 10 tokens with 10k callers
+// blocked in Wait, reporting how many of them it wakes. The broadcast case
+// wakes every queued waiter on each refill instead, for comparison.
+func BenchmarkWaitWakeups(b *testing.B) {
+	for _, broadcast := range []bool{false, true} {
+		name := "head"
+		if broadcast {
+			name = "broadcast"
+		}
+		b.Run(name, func(b *testing.B) {
+			benchmarkWaitWakeups(b, broadcast)
+		})
+	}
+}
+
+func benchmarkWaitWakeups(b *testing.B, broadcast bool) {
+	const blocked, refill = 10_000, 10
+
+	ctx, cancel := context.WithCancel(context.Background())
+	clk := newFakeClock()
+	rl := newFakeLimiter(ctx, Options{BurstAmount: refill, Interval: time.Second, RefillAmount: refill}, clk)
+	rl.Drain()
+
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	// start adds waiters until blocked are queued.
+	start := func() {
+		for n := rl.Stats().WaitersBlocked; n < blocked; n++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rl.Wait(ctx)
+			}()
+		}
+		for rl.Stats().WaitersBlocked < blocked {
+			runtime.Gosched()
+		}
+	}
+	start()
+
+	rl.wakeups.Store(0)
+	b.ResetTimer()
+	for range b.N {
+		clk.Advance(time.Second)
+		if broadcast {
+			rl.mu.Lock()
+			for _, w := range rl.queue {
+				select {
+				case w.ready <- struct{}{}:
+					rl.wakeups.Add(1)
+				default:
+				}
+			}
+			rl.mu.Unlock()
+		}
+		for rl.Stats().WaitersBlocked > blocked-refill {
+			runtime.Gosched()
+		}
+
+		b.StopTimer()
+		start()
+		b.StartTimer()
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(rl.wakeups.Load())/float64(b.N), "wakeups/refill")
+}
@@ -2,33 +2,241 @@ package ratelimiter
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var (
+	// ErrClosed is returned by Wait when the limiter has been closed.
+	ErrClosed = errors.New("ratelimiter: limiter is closed")
+	// ErrExceedsBurst is returned by WaitN when n is larger than MaxBurst,
+	// so the request could never be satisfied, unless the limiter allows
+	// oversized requests.
+	ErrExceedsBurst = errors.New("ratelimiter: n exceeds max burst")
+	// ErrQueueFull is returned when a caller would have to queue behind
+	// more waiters than the limiter accepts.
+	ErrQueueFull = errors.New("ratelimiter: wait queue is full")
+	// ErrInvalidOptions is wrapped by the errors Options.Validate returns.
+	ErrInvalidOptions = errors.New("ratelimiter: invalid options")
+	// ErrWouldBlock is returned by UseNOrErr when the tokens are not usable
+	// right now but could be later.
+	ErrWouldBlock = errors.New("ratelimiter: tokens not available now")
+	// ErrWaitTimeout is returned by Wait and the other blocking calls when
+	// they give up because the deadline of their context passed. It always
+	// comes wrapped together with context.DeadlineExceeded, while a context
+	// that was cancelled yields context.Canceled alone, so errors.Is tells
+	// the two apart.
+	ErrWaitTimeout = errors.New("ratelimiter: wait timed out")
+)
+
+// MaxAllowedBurst is the largest burst a RateLimiter accepts. Larger values
+// passed to the constructors or SetBurst are clamped to it, which keeps the
+// token count exact in its float64 representation. It may be lowered, but
+// only before any limiters are created.
+var MaxAllowedBurst = 1 << 30
+
 type RateLimiter struct {
 	mu sync.Mutex
 
-	burst         uint
-	maxBurst      uint
+	// burst may hold a fraction of a token when refillAmount is fractional,
+	// and drops below zero while tokens are held by reservations that have
+	// not become due yet.
+	burst        float64
+	maxBurst     int
+	refillAmount float64
+
+	// held is the tokens already collected by the head of the queue. They
+	// count towards maxBurst but are no longer available to anyone else.
+	held          float64
 	burstInterval time.Duration
 
+	// burstCooldown, lastRefill and every other time the limiter keeps come
+	// from clock.Now and carry a monotonic reading, so comparing them is
+	// immune to wall clock jumps. Only durations leave the limiter, e.g. in
+	// Snapshot.
 	burstCooldown time.Time
 	interval      time.Duration
-	ticker        *time.Ticker
+	clock         clock
+
+	// ticker drives the refills of non-lazy limiters. It is only ever
+	// stopped or reset with rl.mu held, and set to nil once the refill
+	// goroutine has exited, so that setters cannot restart it.
+	ticker ticker
+
+	// idle is set while the ticker is stopped because the bucket is full,
+	// so that idle limiters do not wake up for nothing. The next consumption
+	// restarts it.
+	idle bool
+
+	// lastRefill is when the bucket was last topped up, by a tick or lazily.
+	// lazy limiters have no ticker or refill goroutine; their tokens accrue
+	// continuously and are computed on demand from the time elapsed since
+	// lastRefill.
+	lazy       bool
+	lastRefill time.Time
+
+	// jitter randomizes every refill tick by up to ±jitter of the interval,
+	// drawing from rand, which is also behind any other random choice of the
+	// limiter. WithRandSource replaces it to get a fixed sequence.
+	jitter float64
+	rand   *rand.Rand
+
+	// allowOversized lets WaitN take more than maxBurst tokens by emptying
+	// a full bucket and leaving it in debt for the rest.
+	allowOversized bool
+
+	// disabled limiters let every take succeed without touching the bucket.
+	// It never changes, so it may be read without holding mu.
+	disabled bool
+
+	// queue holds the blocked Wait calls in the order they are served. Only
+	// the head may take tokens, and it is woken whenever tokens are added.
+	// With a non-zero agingStep waiters gain that much priority for every
+	// agingInterval they are queued.
+	queue         []*waiter
+	agingStep     int
+	agingInterval time.Duration
+
+	// behind counts the tokens ever taken by reservations made while Wait
+	// calls were queued. Those reservations come due after the waiters
+	// queued before them, which may therefore collect their tokens first.
+	behind float64
+
+	closed    bool
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// paused is set between Pause and Resume, during which nothing is
+	// admitted and no tokens accrue.
+	paused   bool
+	pausedAt time.Time
+
+	c     chan struct{}
+	cOnce sync.Once
+
+	allowed   atomic.Uint64
+	denied    atomic.Uint64
+	waiters   atomic.Int64
+	waitCount atomic.Uint64
+	waitTotal atomic.Int64
+
+	// wakeups counts the waiters woken by notify, which the benchmarks
+	// report per refill.
+	wakeups atomic.Uint64
+
+	// fastTokens and fastCooldown back the lock-free path of take, see
+	// lend. The cooldown is kept in nanoseconds since epoch, which is when
+	// the limiter was created, so that it stays on the monotonic clock.
+	// lockOnly turns the lock-free path off, for the benchmarks to compare.
+	fastTokens   atomic.Int64
+	fastCooldown atomic.Int64
+	epoch        time.Time
+	lockOnly     bool
+
+	onAllow atomic.Pointer[func()]
+	onDeny  atomic.Pointer[func()]
+	onFull  atomic.Pointer[func()]
+
+	cost atomic.Pointer[func() int]
+
+	onConfigChange atomic.Pointer[func(old, new Options)]
+
+	// events receives an Event for every Use call once Events has been
+	// called, labelled with key by a KeyedRateLimiter.
+	events atomic.Pointer[chan Event]
+	key    any
 }
 
 // RateLimiterOptions is a struct that holds the options for the RateLimiter
 //
 // # BurstAmount is the amount of uses that can be used in a burst
 //
-// # BurstInterval is the minimum time between each use in a burst
+// # BurstInterval is the minimum time between each use in a burst. It only
+// spaces out uses and never delays refills, but caps the rate at one use per
+// BurstInterval
+//
+// # Interval is the time to wait for the burst to refill by one. Refills run
+// on their own schedule, regardless of how fast the burst is used up
+//
+// # RefillAmount is the number of tokens, possibly fractional, added every
+// Interval, defaults to 1
+//
+// # Jitter randomizes each refill by up to ±Jitter of the Interval (0.0 to
+// 1.0), so that limiters sharing an interval do not refill in lockstep. Lazy
+// limiters ignore it
+//
+// # Disabled turns the limiter into a pass-through that allows everything
+// and runs no refill goroutine, e.g. behind a feature flag. It is fixed when
+// the limiter is created
 //
-// # Interval is the time to wait for the burst to refill by one
+// # InitialBurst is the number of tokens a new limiter starts with (0 to
+// BurstAmount), so that a restart does not allow a full burst at once. Nil
+// starts full
+//
+// # AllowOversizedRequests lets WaitN ask for more than BurstAmount tokens,
+// e.g. for a request whose cost legitimately exceeds the burst. It waits for
+// a full bucket, empties it and then waits for the remainder to refill
+// before returning, during which nobody else is admitted. UseN still rejects
+// them, as it never waits
 type Options struct {
-	BurstAmount   int
-	BurstInterval time.Duration
-	Interval      time.Duration
+	BurstAmount            int
+	BurstInterval          time.Duration
+	Interval               time.Duration
+	RefillAmount           float64
+	Jitter                 float64
+	Disabled               bool
+	InitialBurst           *int
+	AllowOversizedRequests bool
+}
+
+// normalized returns opts with out of range values replaced by the defaults
+// the constructors use.
+func (opts Options) normalized() Options {
+	opts.BurstAmount = clampBurst(opts.BurstAmount)
+	if opts.Interval < 1 {
+		opts.Interval = time.Second
+	}
+	if opts.RefillAmount <= 0 {
+		opts.RefillAmount = 1
+	}
+	opts.Jitter = min(max(opts.Jitter, 0), 1)
+	if opts.InitialBurst != nil {
+		initial := min(max(*opts.InitialBurst, 0), opts.BurstAmount)
+		opts.InitialBurst = &initial
+	}
+	return opts
+}
+
+// Validate reports the first out of range value in opts, instead of
+// correcting it like the constructors do.
+func (opts Options) Validate() error {
+	switch {
+	case opts.BurstAmount < 1:
+		return fmt.Errorf("%w: BurstAmount must be at least 1, got %d", ErrInvalidOptions, opts.BurstAmount)
+	case opts.BurstAmount > MaxAllowedBurst:
+		return fmt.Errorf("%w: BurstAmount must be at most %d, got %d", ErrInvalidOptions, MaxAllowedBurst, opts.BurstAmount)
+	case opts.Interval <= 0:
+		return fmt.Errorf("%w: Interval must be positive, got %s", ErrInvalidOptions, opts.Interval)
+	case opts.BurstInterval < 0:
+		return fmt.Errorf("%w: BurstInterval must not be negative, got %s", ErrInvalidOptions, opts.BurstInterval)
+	case opts.RefillAmount < 0:
+		return fmt.Errorf("%w: RefillAmount must not be negative, got %g", ErrInvalidOptions, opts.RefillAmount)
+	case opts.Jitter < 0 || opts.Jitter > 1:
+		return fmt.Errorf("%w: Jitter must be between 0 and 1, got %g", ErrInvalidOptions, opts.Jitter)
+	case opts.InitialBurst != nil && (*opts.InitialBurst < 0 || *opts.InitialBurst > opts.BurstAmount):
+		return fmt.Errorf("%w: InitialBurst must be between 0 and BurstAmount, got %d", ErrInvalidOptions, *opts.InitialBurst)
+	}
+	return nil
+}
+
+// clampBurst brings n into the range [1, MaxAllowedBurst].
+func clampBurst(n int) int {
+	return min(max(n, 1), max(MaxAllowedBurst, 1))
 }
 
 func NewRateLimiter(ctx context.Context, interval time.Duration) *RateLimiter {
@@ -41,126 +249,1098 @@ func NewRateLimiter(ctx context.Context, interval time.Duration) *RateLimiter {
 }
 
 func NewRateLimiterWithBurst(ctx context.Context, opts Options) *RateLimiter {
-	if opts.BurstAmount < 1 {
-		opts.BurstAmount = 1
-	}
-	if opts.Interval < 1 {
-		opts.Interval = time.Second
-	}
+	rl := newRateLimiter(opts, realClock{})
+	rl.start(ctx)
+	return rl
+}
 
-	rl := &RateLimiter{
-		burst:         uint(opts.BurstAmount),
-		maxBurst:      uint(opts.BurstAmount),
-		interval:      opts.Interval,
-		burstInterval: opts.BurstInterval,
-		burstCooldown: time.Now(),
+// start creates the ticker and runs the refill goroutine until ctx is done
+// or the limiter is closed.
+func (rl *RateLimiter) start(ctx context.Context) {
+	if rl.disabled {
+		return
 	}
-	rl.ticker = time.NewTicker(rl.interval)
-	defer rl.ticker.Stop()
+
+	rl.ticker = rl.clock.NewTicker(rl.nextInterval())
+	ticks := rl.ticker.C()
 
 	go func() {
 		for {
 			select {
 			case <-ctx.Done():
+				rl.lock()
+				if rl.ticker != nil {
+					rl.ticker.Stop()
+					rl.ticker = nil
+				}
+				rl.idle = false
+				rl.unlock()
 				return
-			case <-rl.ticker.C:
-				if rl.burst < rl.maxBurst {
-					rl.mu.Lock()
-					rl.burst += 1
-					rl.mu.Unlock()
+			case <-rl.done:
+				return
+			case now := <-ticks:
+				rl.lock()
+				if rl.closed {
+					// A tick sent before Close stopped the ticker; resetting
+					// it now would restart it.
+					rl.unlock()
+					return
+				}
+				if rl.paused {
+					// Resume restarts the ticker.
+					rl.ticker.Stop()
+					rl.unlock()
+					continue
+				}
+				rl.lastRefill = now
+				before := rl.burst
+				rl.add(rl.refillAmount)
+				onFull := rl.filled(before)
+				if rl.burst >= rl.capacity() {
+					rl.ticker.Stop()
+					rl.idle = true
+				} else {
+					// Reset even without jitter, as SetInterval may have
+					// shortened this one tick.
+					rl.resetTicker(rl.nextInterval())
+				}
+				rl.unlock()
+
+				if onFull != nil {
+					onFull()
 				}
 			}
 		}
 	}()
+}
+
+// NewRateLimiterWithBurstChecked is like NewRateLimiterWithBurst but returns
+// the error from opts.Validate instead of correcting invalid options.
+func NewRateLimiterWithBurstChecked(ctx context.Context, opts Options) (*RateLimiter, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return NewRateLimiterWithBurst(ctx, opts), nil
+}
 
+// NewLazyRateLimiter returns a limiter that refills on demand, computing the
+// available tokens from the time elapsed since the last refill on every call.
+// It runs no background goroutine, so no context is needed to stop it.
+func NewLazyRateLimiter(opts Options) *RateLimiter {
+	rl := newRateLimiter(opts, realClock{})
+	rl.lazy = true
 	return rl
 }
 
+// Clone returns a new limiter with the same configuration as rl, e.g. to
+// build a pool of identically configured limiters, refilled until ctx is
+// done like NewRateLimiterWithBurst. A clone of a lazy limiter is lazy too
+// and ignores ctx. Only the configuration is copied: the clone starts with a
+// full bucket of its own, and none of the tokens, cooldown, waiters, pause,
+// statistics or callbacks of rl carry over.
+func (rl *RateLimiter) Clone(ctx context.Context) *RateLimiter {
+	rl.lock()
+	opts := rl.options()
+	lazy := rl.lazy
+	step, interval := rl.agingStep, rl.agingInterval
+	rl.unlock()
+
+	clone := newRateLimiter(opts, rl.clock)
+	clone.lazy = lazy
+	clone.agingStep, clone.agingInterval = step, interval
+	if !lazy {
+		clone.start(ctx)
+	}
+	return clone
+}
+
+func newRateLimiter(opts Options, clk clock) *RateLimiter {
+	opts = opts.normalized()
+
+	burst := opts.BurstAmount
+	if opts.InitialBurst != nil {
+		burst = *opts.InitialBurst
+	}
+
+	now := clk.Now()
+	return &RateLimiter{
+		burst:          float64(burst),
+		maxBurst:       opts.BurstAmount,
+		refillAmount:   opts.RefillAmount,
+		interval:       opts.Interval,
+		burstInterval:  opts.BurstInterval,
+		burstCooldown:  now,
+		lastRefill:     now,
+		epoch:          now,
+		clock:          clk,
+		jitter:         opts.Jitter,
+		disabled:       opts.Disabled,
+		allowOversized: opts.AllowOversizedRequests,
+		rand:           rand.New(rand.NewSource(now.UnixNano())),
+		done:           make(chan struct{}),
+	}
+}
+
+// options returns the current configuration. The caller must hold rl.mu.
+func (rl *RateLimiter) options() Options {
+	return Options{
+		BurstAmount:            rl.maxBurst,
+		BurstInterval:          rl.burstInterval,
+		Interval:               rl.interval,
+		RefillAmount:           rl.refillAmount,
+		Jitter:                 rl.jitter,
+		Disabled:               rl.disabled,
+		AllowOversizedRequests: rl.allowOversized,
+	}
+}
+
+// Use consumes a token if one is available and the burst cooldown has
+// elapsed. It fails while Wait calls are queued, so that they are not
+// starved by callers that never wait.
 func (rl *RateLimiter) Use() bool {
-	if rl.burst > 0 && rl.burstCooldown.Before(time.Now()) {
-		rl.mu.Lock()
-		defer rl.mu.Unlock()
+	return rl.UseN(1)
+}
+
+// UseN consumes n tokens at once if they are all available and the burst
+// cooldown has elapsed. It returns false without consuming anything
+// otherwise, including when n exceeds MaxBurst. n < 1 is treated as 1.
+func (rl *RateLimiter) UseN(n int) bool {
+	ok, _ := rl.use(n, rl.clock.Now())
+	return ok
+}
 
-		rl.burstCooldown = time.Now().Add(rl.burstInterval)
-		rl.burst -= 1
-		rl.ticker.Reset(rl.interval)
-		return true
+// UseNOrErr is like UseN but reports the outcome as an error, telling apart
+// requests to try again later from ones that can never succeed: it returns
+// nil if the tokens were consumed, ErrWouldBlock if they are not usable
+// right now, ErrExceedsBurst if n is larger than MaxBurst and ErrClosed if
+// the limiter is closed.
+func (rl *RateLimiter) UseNOrErr(n int) error {
+	ok, err := rl.use(n, rl.clock.Now())
+	if !ok && err == nil {
+		return ErrWouldBlock
 	}
+	return err
+}
 
-	return false
+// use implements UseN at now, also returning why tokens could never be
+// taken.
+func (rl *RateLimiter) use(n int, now time.Time) (bool, error) {
+	ok, _, err := rl.take(n, now)
+	rl.decided(ok, now)
+	return ok, err
 }
 
-func (rl *RateLimiter) Wait(ctx context.Context) {
-	allow := make(chan struct{})
-	defer close(allow)
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				if rl.Use() {
-					allow <- struct{}{}
-					return
-				}
-			}
+// decided accounts for a Use-like call that was allowed or denied at now:
+// it emits the Event, counts denials, which takeLocked does not, and calls
+// the OnAllow or OnDeny callback.
+func (rl *RateLimiter) decided(ok bool, now time.Time) {
+	rl.emit(ok, now)
+
+	callback := rl.onAllow.Load()
+	if !ok {
+		rl.denied.Add(1)
+		callback = rl.onDeny.Load()
+	}
+	if callback != nil {
+		(*callback)()
+	}
+}
+
+// SetOnAllow registers fn to be called after every Use or UseN call that
+// consumed tokens. Pass nil to remove it. fn is called without holding the
+// limiter's lock, so it may call back into the limiter, but it runs on the
+// caller's goroutine and must be fast and non-blocking.
+func (rl *RateLimiter) SetOnAllow(fn func()) {
+	rl.onAllow.Store(callback(fn))
+}
+
+// SetOnDeny is like SetOnAllow but for calls that were denied.
+func (rl *RateLimiter) SetOnDeny(fn func()) {
+	rl.onDeny.Store(callback(fn))
+}
+
+// SetOnConfigChange registers fn to be called with the configuration before
+// and after every SetBurst, SetBurstAndFill, SetBurstInterval, SetInterval,
+// SetLimit, SetOptions or Restore call that changed it, e.g. to keep an
+// audit log of dynamic limits. Pass nil to remove it. fn is called without
+// holding the limiter's lock, on the goroutine of the setter.
+func (rl *RateLimiter) SetOnConfigChange(fn func(old, new Options)) {
+	if fn == nil {
+		rl.onConfigChange.Store(nil)
+		return
+	}
+	rl.onConfigChange.Store(&fn)
+}
+
+// reconfigure runs change with rl.mu held and reports the configuration
+// change, if any, to the OnConfigChange callback once the lock is released.
+// A change that leaves room in a full bucket, such as a larger burst,
+// restarts the refill ticker if it was idle, and the head of the queue is
+// woken to check its request against the new configuration, as a burst
+// lowered below the tokens it holds leaves no room for refills to wake it.
+func (rl *RateLimiter) reconfigure(change func()) {
+	rl.lock()
+	old := rl.options()
+	change()
+	if !rl.paused && rl.burst < rl.capacity() {
+		rl.wakeTicker(rl.clock.Now())
+	}
+	rl.notify()
+	updated := rl.options()
+	rl.unlock()
+
+	if fn := rl.onConfigChange.Load(); fn != nil && updated != old {
+		(*fn)(old, updated)
+	}
+}
+
+// SetOnFull registers fn to be called when a refill fills the bucket up to
+// MaxBurst, e.g. to detect that the limiter is underutilized. It is called
+// once per transition to full, not on every refill while the bucket stays
+// full. Pass nil to remove it. fn is called without holding the limiter's
+// lock; ticker-driven limiters call it on the refill goroutine, while lazy
+// limiters, which refill inside other calls, start a new goroutine for it.
+func (rl *RateLimiter) SetOnFull(fn func()) {
+	rl.onFull.Store(callback(fn))
+}
+
+func callback(fn func()) *func() {
+	if fn == nil {
+		return nil
+	}
+	return &fn
+}
+
+// SetCostFunc registers fn to compute the number of tokens each UseCost call
+// consumes, for operations whose cost is only known at call time, such as
+// the size of a request. Pass nil to remove it, after which UseCost consumes
+// a single token. fn is called without holding the limiter's lock.
+func (rl *RateLimiter) SetCostFunc(fn func() int) {
+	if fn == nil {
+		rl.cost.Store(nil)
+		return
+	}
+	rl.cost.Store(&fn)
+}
+
+// UseCost is like UseN with n computed by the function registered with
+// SetCostFunc.
+func (rl *RateLimiter) UseCost() bool {
+	n := 1
+	if cost := rl.cost.Load(); cost != nil {
+		n = (*cost)()
+	}
+	return rl.UseN(n)
+}
+
+// CanUse reports whether Use would succeed right now, taking both the tokens
+// and the burst cooldown into account, without consuming anything.
+func (rl *RateLimiter) CanUse() bool {
+	rl.lock()
+	defer rl.unlock()
+
+	if rl.disabled {
+		return !rl.closed
+	}
+	now := rl.clock.Now()
+	rl.refill(now)
+	return !rl.closed && !rl.paused && len(rl.queue) == 0 && rl.burst >= 1 && !rl.burstCooldown.After(now)
+}
+
+// UseContext is like Use, but when a token is available and only the burst
+// cooldown keeps it from being used, it waits for the cooldown to elapse
+// instead of failing. It never waits for a refill: with no token available
+// it returns false straight away. It returns the context error if ctx is
+// done before the cooldown elapses, and ErrClosed if the limiter is closed.
+func (rl *RateLimiter) UseContext(ctx context.Context) (bool, error) {
+	for {
+		now := rl.clock.Now()
+		ok, _, err := rl.take(1, now)
+		if ok || err != nil {
+			return ok, err
 		}
-	}()
-	<-allow
+
+		cooldown := rl.cooldownLeft(now)
+		if cooldown <= 0 {
+			return false, nil
+		}
+		if err := rl.sleep(ctx, cooldown, nil); err != nil {
+			return false, err
+		}
+	}
+}
+
+// UseBlockingCooldown is like UseContext without a context, for callers that
+// would rather block for a few milliseconds than be denied a token that is
+// already there. It gives up straight away if the cooldown left is longer
+// than BurstInterval, as it can be after Reserve.
+func (rl *RateLimiter) UseBlockingCooldown() bool {
+	if rl.cooldownLeft(rl.clock.Now()) > rl.BurstInterval() {
+		return rl.Use()
+	}
+
+	ok, _ := rl.UseContext(context.Background())
+	return ok
+}
+
+// Drain consumes every token currently available and starts the burst
+// cooldown, returning how many tokens it took. Like Use, it takes nothing
+// while the cooldown has not elapsed or Wait calls are queued. A disabled
+// limiter leaves its bucket alone and reports a full MaxBurst taken.
+func (rl *RateLimiter) Drain() int {
+	rl.lock()
+	defer rl.unlock()
+
+	if rl.disabled && !rl.closed {
+		rl.allowed.Add(1)
+		return rl.maxBurst
+	}
+	now := rl.clock.Now()
+	rl.refill(now)
+	if rl.closed || rl.paused || len(rl.queue) > 0 || rl.burst < 1 || rl.burstCooldown.After(now) {
+		return 0
+	}
+
+	n := rl.whole()
+	rl.burst -= float64(n)
+	rl.wakeTicker(now)
+	rl.burstCooldown = now.Add(rl.burstInterval)
+	rl.allowed.Add(1)
+	return n
+}
+
+// Refund gives back up to n tokens that were consumed for work that turned
+// out to be a no-op, never filling the bucket beyond MaxBurst, and wakes a
+// blocked Wait if there is one. It is the counterpart of Reservation.Cancel
+// for tokens that were already used.
+func (rl *RateLimiter) Refund(n int) {
+	if n < 1 {
+		return
+	}
+
+	rl.lock()
+	defer rl.unlock()
+
+	rl.refill(rl.clock.Now())
+	rl.add(float64(n))
+}
+
+// AllowAt reports whether n tokens would be usable at t, assuming nothing
+// else consumes tokens meanwhile, using the same refill math as the limiter
+// itself. It consumes nothing, which makes it suitable for look-ahead
+// scheduling and for tests that should not sleep. A t in the past is
+// evaluated as now.
+func (rl *RateLimiter) AllowAt(n int, t time.Time) bool {
+	rl.lock()
+	defer rl.unlock()
+
+	now := rl.clock.Now()
+	rl.refill(now)
+	if rl.closed || n > rl.maxBurst {
+		return false
+	}
+	if t.Before(now) {
+		t = now
+	}
+	// A t from elsewhere may lack a monotonic reading; move it onto now's so
+	// that it compares against the limiter's own times like they do against
+	// each other.
+	t = now.Add(t.Sub(now))
+
+	return rl.tokensAt(t) >= float64(max(n, 1)) && !rl.burstCooldown.After(t)
+}
+
+// Allow is an alias for Use, named after golang.org/x/time/rate to ease
+// migration from that package. It consumes a token and starts the burst
+// cooldown exactly like Use does.
+func (rl *RateLimiter) Allow() bool {
+	return rl.Use()
+}
+
+// AllowN is an alias for UseN, see Allow.
+func (rl *RateLimiter) AllowN(n int) bool {
+	return rl.UseN(n)
+}
+
+// Wait blocks until a token is consumed. It returns ErrClosed if the limiter
+// is closed while waiting. If ctx is done first it returns context.Canceled,
+// or an error wrapping both ErrWaitTimeout and context.DeadlineExceeded if
+// the deadline of ctx passed; the other blocking calls follow the same
+// contract when they report "the context error".
+func (rl *RateLimiter) Wait(ctx context.Context) error {
+	return rl.WaitN(ctx, 1)
+}
+
+// WaitN blocks until n tokens are consumed at once. It returns
+// ErrExceedsBurst straight away if n is larger than MaxBurst, unless the
+// limiter allows oversized requests, and otherwise the same errors as Wait.
+// n < 1 is treated as 1.
+func (rl *RateLimiter) WaitN(ctx context.Context, n int) error {
+	return rl.wait(ctx, n, 0, noQueueLimit)
+}
+
+// TryUse is like Wait but gives up after timeout, reporting whether a token
+// was consumed.
+func (rl *RateLimiter) TryUse(timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return rl.Wait(ctx) == nil
+}
+
+// WaitDeadline is like Wait for callers holding an absolute deadline. It
+// returns an error wrapping ErrWaitTimeout and context.DeadlineExceeded if
+// the deadline passes before a token is consumed.
+func (rl *RateLimiter) WaitDeadline(deadline time.Time) error {
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	return rl.Wait(ctx)
+}
+
+// WaitTimed is like Wait but also returns how long it blocked, which is zero
+// if a token was available straight away, for callers that adapt their
+// backoff to it.
+func (rl *RateLimiter) WaitTimed(ctx context.Context) (time.Duration, error) {
+	start := rl.clock.Now()
+	ok, _, err := rl.take(1, start)
+	if ok || err != nil {
+		return 0, err
+	}
+
+	err = rl.wait(ctx, 1, 0, noQueueLimit)
+	return rl.clock.Now().Sub(start), err
+}
+
+// WaitWithJitter is like Wait but, once the token is consumed, sleeps for a
+// random duration of up to maxJitter before returning, so that callers
+// unblocked by the same refill do not all hit the downstream service at
+// once. It returns the context error if ctx is done during the extra sleep;
+// the token is spent either way.
+func (rl *RateLimiter) WaitWithJitter(ctx context.Context, maxJitter time.Duration) error {
+	if err := rl.Wait(ctx); err != nil {
+		return err
+	}
+	if maxJitter <= 0 {
+		return nil
+	}
+
+	rl.lock()
+	d := time.Duration(rl.rand.Int63n(int64(maxJitter) + 1))
+	rl.unlock()
+
+	timer := rl.clock.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctxErr(ctx)
+	case <-timer.C():
+		return nil
+	}
+}
+
+// take consumes n tokens if they are usable at now and nobody is queued for
+// them, trying the lock-free path before taking rl.mu.
+func (rl *RateLimiter) take(n int, now time.Time) (bool, time.Duration, error) {
+	if rl.takeFast(n, now) {
+		return true, 0, nil
+	}
+
+	rl.lock()
+	defer rl.unlock()
+
+	if len(rl.queue) > 0 && !rl.closed && !rl.disabled && n <= rl.maxBurst {
+		return false, 0, nil
+	}
+	return rl.takeLocked(n, now)
+}
+
+// takeLocked consumes n tokens if they are usable right now. Otherwise it
+// returns how long the caller should wait before trying again (zero if only
+// the next refill notification will help), or an error if the request can
+// never succeed. The caller must hold rl.mu.
+func (rl *RateLimiter) takeLocked(n int, now time.Time) (bool, time.Duration, error) {
+	if n < 1 {
+		n = 1
+	}
+	if rl.closed {
+		return false, 0, ErrClosed
+	}
+	if rl.disabled {
+		rl.allowed.Add(1)
+		return true, 0, nil
+	}
+	if n > rl.maxBurst {
+		return false, 0, ErrExceedsBurst
+	}
+	if rl.paused {
+		return false, 0, nil
+	}
+
+	rl.refill(now)
+	if rl.burst >= float64(n) {
+		if rl.burstCooldown.After(now) {
+			return false, rl.burstCooldown.Sub(now), nil
+		}
+
+		rl.burstCooldown = now.Add(rl.burstInterval)
+		rl.burst -= float64(n)
+		rl.wakeTicker(now)
+		rl.allowed.Add(1)
+		return true, 0, nil
+	}
+
+	if rl.lazy {
+		// Nothing will notify a lazy limiter, so sleep until enough tokens
+		// have accrued.
+		return false, rl.due(float64(n), now).Sub(now), nil
+	}
+
+	return false, 0, nil
+}
+
+// cooldownLeft returns how long after now the burst cooldown still keeps an
+// available token from being used, or zero if no token is available to Use.
+func (rl *RateLimiter) cooldownLeft(now time.Time) time.Duration {
+	rl.lock()
+	defer rl.unlock()
+
+	rl.refill(now)
+	if len(rl.queue) > 0 || rl.burst < 1 {
+		return 0
+	}
+	return max(rl.burstCooldown.Sub(now), 0)
+}
+
+// TimeToNext returns how long until at least one token will be usable,
+// taking both the refill and the burst cooldown into account, or zero if
+// one is usable right now. It is what Middleware sends as Retry-After, and
+// assumes nobody else takes tokens meanwhile.
+func (rl *RateLimiter) TimeToNext() time.Duration {
+	return rl.delay(rl.clock.Now())
+}
+
+// delay returns how long after now a single token can be taken, or zero if
+// one can be taken by then.
+func (rl *RateLimiter) delay(now time.Time) time.Duration {
+	rl.lock()
+	defer rl.unlock()
+
+	rl.refill(now)
+
+	at := rl.burstCooldown
+	if due := rl.due(1, now); due.After(at) {
+		at = due
+	}
+
+	return max(at.Sub(now), 0)
+}
+
+// due returns when the bucket will hold n tokens if none are taken
+// meanwhile, or now if it already does. The caller must hold rl.mu and have
+// refilled the bucket up to now.
+func (rl *RateLimiter) due(n float64, now time.Time) time.Time {
+	missing := n - rl.burst
+	if missing <= 0 {
+		return now
+	}
+
+	intervals := missing / rl.refillAmount
+	if rl.lazy {
+		return now.Add(time.Duration(math.Ceil(intervals * float64(rl.interval))))
+	}
+	return rl.lastRefill.Add(time.Duration(math.Ceil(intervals)) * rl.interval)
+}
+
+// tokensAt returns the tokens the bucket will hold at t, which must not be
+// before lastRefill, if none are taken meanwhile. The caller must hold rl.mu
+// and have refilled the bucket.
+func (rl *RateLimiter) tokensAt(t time.Time) float64 {
+	intervals := float64(t.Sub(rl.lastRefill)) / float64(rl.interval)
+	if !rl.lazy {
+		// Ticker-driven limiters only refill on whole ticks.
+		intervals = math.Floor(intervals)
+	}
+	return min(rl.burst+intervals*rl.refillAmount, max(rl.burst, rl.capacity()))
+}
+
+// whole returns the whole tokens in the bucket, rounding down. The caller
+// must hold rl.mu.
+func (rl *RateLimiter) whole() int {
+	return int(math.Floor(rl.burst))
+}
+
+// add puts amount tokens back into the bucket, never beyond maxBurst, and
+// wakes the head of the queue. The caller must hold rl.mu.
+func (rl *RateLimiter) add(amount float64) {
+	if rl.burst >= rl.capacity() {
+		return
+	}
+
+	rl.burst = min(rl.burst+amount, rl.capacity())
+	rl.notify()
+}
+
+// capacity returns how many tokens the bucket can hold besides those held
+// by the head of the queue. The caller must hold rl.mu.
+func (rl *RateLimiter) capacity() float64 {
+	return float64(rl.maxBurst) - rl.held
+}
+
+// wakeTicker restarts the refill ticker if it was stopped while the bucket
+// was full, now that tokens have been consumed or the bucket has grown. The
+// caller must hold rl.mu.
+func (rl *RateLimiter) wakeTicker(now time.Time) {
+	if !rl.idle || rl.closed {
+		return
+	}
+
+	// No tokens were missed while the bucket was full, so the next one is
+	// due a whole interval from now.
+	rl.lastRefill = now
+	rl.resetTicker(rl.nextInterval())
+}
+
+// resetTicker restarts the refill ticker with d, which also wakes it if it
+// was idle. The caller must hold rl.mu.
+func (rl *RateLimiter) resetTicker(d time.Duration) {
+	rl.ticker.Reset(d)
+	rl.idle = false
+}
+
+// nextInterval returns the interval until the next tick, randomized by the
+// configured jitter. The caller must hold rl.mu unless rl is not shared yet.
+func (rl *RateLimiter) nextInterval() time.Duration {
+	if rl.jitter == 0 {
+		return rl.interval
+	}
+
+	offset := rl.jitter * (2*rl.rand.Float64() - 1)
+	return max(time.Duration(float64(rl.interval)*(1+offset)), 1)
+}
+
+// refill adds the tokens accrued since lastRefill to a lazy limiter. It is a
+// no-op for ticker-driven limiters. The caller must hold rl.mu.
+func (rl *RateLimiter) refill(now time.Time) {
+	if !rl.lazy || rl.paused || !now.After(rl.lastRefill) {
+		return
+	}
+
+	elapsed := float64(now.Sub(rl.lastRefill)) / float64(rl.interval)
+	before := rl.burst
+	rl.burst = min(rl.burst+elapsed*rl.refillAmount, max(rl.burst, rl.capacity()))
+	rl.lastRefill = now
+	if onFull := rl.filled(before); onFull != nil {
+		go onFull()
+	}
+}
+
+// filled returns the OnFull callback if a refill from before has just
+// brought the bucket up to full, and nil otherwise. The caller must hold
+// rl.mu.
+func (rl *RateLimiter) filled(before float64) func() {
+	if before >= rl.capacity() || rl.burst < rl.capacity() {
+		return nil
+	}
+	if fn := rl.onFull.Load(); fn != nil {
+		return *fn
+	}
+	return nil
+}
+
+// sleep blocks until wake is signalled or the cooldown (if any) elapses, in
+// which case the caller should try to take a token again. It returns an
+// error if ctx is done or the limiter is closed first.
+func (rl *RateLimiter) sleep(ctx context.Context, cooldown time.Duration, wake <-chan struct{}) error {
+	var timer <-chan time.Time
+	if cooldown > 0 {
+		t := rl.clock.NewTimer(cooldown)
+		defer t.Stop()
+		timer = t.C()
+	}
+
+	select {
+	case <-wake:
+		return nil
+	case <-timer:
+		return nil
+	case <-ctx.Done():
+		return ctxErr(ctx)
+	case <-rl.done:
+		return ErrClosed
+	}
+}
+
+// ctxErr returns the error of ctx, which is done, following the contract
+// described for Wait.
+func ctxErr(ctx context.Context) error {
+	err := ctx.Err()
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrWaitTimeout, err)
+	}
+	return err
+}
+
+// notify wakes the waiter at the head of the queue, if any, so that it
+// re-checks the tokens. The caller must hold rl.mu.
+func (rl *RateLimiter) notify() {
+	if len(rl.queue) == 0 {
+		return
+	}
+
+	select {
+	case rl.queue[0].ready <- struct{}{}:
+		rl.wakeups.Add(1)
+	default:
+	}
 }
 
 func (rl *RateLimiter) MaxBurst() int {
-	return int(rl.maxBurst)
+	rl.lock()
+	defer rl.unlock()
+
+	return rl.maxBurst
 }
 
 func (rl *RateLimiter) CurrentBurst() int {
-	return int(rl.burst)
+	rl.lock()
+	defer rl.unlock()
+
+	rl.refill(rl.clock.Now())
+	return rl.whole()
 }
 
-func (rl *RateLimiter) SetBurst(newMaxBurst int) {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
+// Tokens returns the whole tokens currently available plus the fraction of
+// the interval that has elapsed towards the next refill, so 2.4 means two
+// tokens and 40% of the way to a third.
+func (rl *RateLimiter) Tokens() float64 {
+	rl.lock()
+	defer rl.unlock()
 
-	if newMaxBurst < 1 {
-		newMaxBurst = 1
+	now := rl.clock.Now()
+	rl.refill(now)
+	if rl.lazy || rl.burst >= rl.capacity() {
+		return rl.burst
 	}
 
-	rl.maxBurst = uint(newMaxBurst)
+	progress := float64(now.Sub(rl.lastRefill)) / float64(rl.interval)
+	return min(rl.burst+min(max(progress, 0), 1)*rl.refillAmount, rl.capacity())
 }
 
+// UsableBurst returns the tokens that Use could actually consume right now.
+// Unlike CurrentBurst, which reports the raw token count, it is 0 while the
+// burst cooldown has not elapsed, while Wait calls are queued, and once the
+// limiter is paused or closed.
+func (rl *RateLimiter) UsableBurst() int {
+	rl.lock()
+	defer rl.unlock()
+
+	now := rl.clock.Now()
+	rl.refill(now)
+	if rl.closed || rl.paused || len(rl.queue) > 0 || rl.burstCooldown.After(now) {
+		return 0
+	}
+	return max(rl.whole(), 0)
+}
+
+func (rl *RateLimiter) SetBurst(newMaxBurst int) {
+	rl.reconfigure(func() {
+		rl.refill(rl.clock.Now())
+		rl.maxBurst = clampBurst(newMaxBurst)
+		rl.burst = min(rl.burst, rl.capacity())
+	})
+}
+
+// SetBurstAndFill is like SetBurst but also fills the bucket up to the new
+// maximum at once, e.g. to grant the extra headroom right away when scaling
+// up capacity in response to load.
+func (rl *RateLimiter) SetBurstAndFill(newMaxBurst int) {
+	rl.reconfigure(func() {
+		rl.refill(rl.clock.Now())
+		rl.maxBurst = clampBurst(newMaxBurst)
+		rl.burst = rl.capacity()
+		rl.notify()
+	})
+}
+
+// ResetBurst fills the bucket up to MaxBurst and wakes a blocked Wait, so
+// that it can take the new tokens straight away.
 func (rl *RateLimiter) ResetBurst() {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
+	rl.lock()
+	defer rl.unlock()
+
+	rl.burst = rl.capacity()
+	rl.notify()
+}
 
-	rl.burst = rl.maxBurst
+// Reset gives the limiter a clean slate: unlike ResetBurst, which only
+// refills the tokens, it also ends the burst cooldown and restarts the
+// refill interval from now.
+func (rl *RateLimiter) Reset() {
+	rl.lock()
+	defer rl.unlock()
+
+	now := rl.clock.Now()
+	rl.burst = rl.capacity()
+	rl.burstCooldown = now
+	rl.lastRefill = now
+	if rl.ticker != nil && !rl.closed {
+		rl.resetTicker(rl.nextInterval())
+	}
+	rl.notify()
 }
 
 func (rl *RateLimiter) BurstInterval() time.Duration {
+	rl.lock()
+	defer rl.unlock()
+
 	return rl.burstInterval
 }
 
 func (rl *RateLimiter) SetBurstInterval(newBurstInterval time.Duration) {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
 	if newBurstInterval < 1 {
 		newBurstInterval = time.Second
 	}
 
-	rl.burstInterval = newBurstInterval
+	rl.reconfigure(func() {
+		rl.burstInterval = newBurstInterval
+	})
+}
+
+// EffectiveRate returns the steady-state number of uses per second the
+// limiter permits once its burst is used up: the refill rate, capped by
+// one use per BurstInterval. A ticker-driven limiter refills at most
+// MaxBurst tokens per tick, which also bounds the rate.
+func (rl *RateLimiter) EffectiveRate() float64 {
+	rl.lock()
+	defer rl.unlock()
+
+	if rl.disabled {
+		return math.Inf(1)
+	}
+
+	perInterval := rl.refillAmount
+	if !rl.lazy {
+		perInterval = min(perInterval, float64(rl.maxBurst))
+	}
+	rate := perInterval / rl.interval.Seconds()
+	if rl.burstInterval > 0 {
+		rate = min(rate, 1/rl.burstInterval.Seconds())
+	}
+	return rate
 }
 
 func (rl *RateLimiter) Interval() time.Duration {
+	rl.lock()
+	defer rl.unlock()
+
 	return rl.interval
 }
 
+// SetInterval changes the refill interval without losing refill progress:
+// the time elapsed since the last refill counts towards the next one, which
+// happens once a full new interval has passed since the last refill, or
+// immediately if that moment is already behind us.
 func (rl *RateLimiter) SetInterval(newInterval time.Duration) {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
 	if newInterval < 1 {
 		newInterval = time.Second
 	}
 
+	rl.reconfigure(func() {
+		rl.setInterval(newInterval)
+	})
+}
+
+// setInterval implements SetInterval. The caller must hold rl.mu.
+func (rl *RateLimiter) setInterval(newInterval time.Duration) {
+	now := rl.clock.Now()
+	rl.refill(now)
 	rl.interval = newInterval
-	rl.ticker.Reset(rl.interval)
+	if rl.ticker == nil || rl.closed || rl.paused {
+		// Resume restarts a paused ticker with the new interval.
+		return
+	}
+
+	remaining := rl.lastRefill.Add(rl.interval).Sub(now)
+	if remaining > 0 {
+		rl.resetTicker(remaining)
+		return
+	}
+
+	rl.lastRefill = now
+	before := rl.burst
+	rl.add(rl.refillAmount)
+	if onFull := rl.filled(before); onFull != nil {
+		go onFull()
+	}
+	rl.resetTicker(rl.nextInterval())
+}
+
+// SetOptions reconfigures the limiter from opts in one step, instead of
+// calling SetBurst, SetBurstInterval and SetInterval in turn and going
+// through the states in between. It applies the burst, both intervals, the
+// refill amount, the jitter and AllowOversizedRequests, keeping refill
+// progress like SetInterval and dropping tokens beyond the new burst like
+// SetBurst. Disabled and InitialBurst are fixed when the limiter is created
+// and ignored here. It returns the error from opts.Validate without changing
+// anything if opts is invalid.
+func (rl *RateLimiter) SetOptions(opts Options) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	rl.setOptions(opts)
+	return nil
+}
+
+// setOptions is SetOptions without the validation, correcting opts the way
+// the constructors do instead.
+func (rl *RateLimiter) setOptions(opts Options) {
+	opts = opts.normalized()
+
+	rl.reconfigure(func() {
+		rl.applyOptions(opts)
+	})
+}
+
+// applyOptions applies the part of the normalized opts that SetOptions
+// changes. The caller must hold rl.mu.
+func (rl *RateLimiter) applyOptions(opts Options) {
+	rl.refill(rl.clock.Now())
+	rl.maxBurst = opts.BurstAmount
+	rl.burst = min(rl.burst, rl.capacity())
+	rl.burstInterval = opts.BurstInterval
+	rl.refillAmount = opts.RefillAmount
+	rl.jitter = opts.Jitter
+	rl.allowOversized = opts.AllowOversizedRequests
+	rl.setInterval(opts.Interval)
+}
+
+// Limit returns the refill rate in tokens per second, like the method of
+// the same name in golang.org/x/time/rate.
+func (rl *RateLimiter) Limit() float64 {
+	rl.lock()
+	defer rl.unlock()
+
+	return rl.refillAmount / rl.interval.Seconds()
+}
+
+// SetLimit sets the refill rate in tokens per second by changing the
+// interval, like SetInterval. A limit that is not positive resets the
+// interval to one second.
+func (rl *RateLimiter) SetLimit(limit float64) {
+	rl.reconfigure(func() {
+		var interval time.Duration
+		if limit > 0 {
+			interval = math.MaxInt64
+			// Very small limits would overflow a Duration.
+			if d := math.Round(rl.refillAmount / limit * float64(time.Second)); d < math.MaxInt64 {
+				interval = time.Duration(d)
+			}
+		}
+		if interval < 1 {
+			interval = time.Second
+		}
+		rl.setInterval(interval)
+	})
+}
+
+// Burst returns MaxBurst, under the name golang.org/x/time/rate uses.
+func (rl *RateLimiter) Burst() int {
+	return rl.MaxBurst()
+}
+
+// C returns a channel that receives a value every time a token has been
+// consumed on the caller's behalf, like a throttled time.Ticker, for use in
+// select loops. The token is taken before the value is sent, so at most one
+// token is held by the channel at a time. The channel is closed, and its
+// producer goroutine exits, when the limiter is closed.
+func (rl *RateLimiter) C() <-chan struct{} {
+	rl.cOnce.Do(func() {
+		rl.c = make(chan struct{})
+		go func() {
+			defer close(rl.c)
+			for {
+				if err := rl.Wait(context.Background()); err != nil {
+					return
+				}
+
+				select {
+				case rl.c <- struct{}{}:
+				case <-rl.done:
+					return
+				}
+			}
+		}()
+	})
+	return rl.c
+}
+
+// String returns a compact summary of the limiter for logs and test
+// failures, e.g. RateLimiter{burst=3/10 interval=1s burstInterval=100ms cooldownIn=43ms}.
+func (rl *RateLimiter) String() string {
+	rl.lock()
+	defer rl.unlock()
+
+	now := rl.clock.Now()
+	rl.refill(now)
+	return fmt.Sprintf("RateLimiter{burst=%d/%d interval=%s burstInterval=%s cooldownIn=%s}",
+		rl.whole(), rl.maxBurst, rl.interval, rl.burstInterval, max(rl.burstCooldown.Sub(now), 0))
+}
+
+// Pause freezes the limiter, e.g. during a maintenance window: until Resume
+// is called, Use denies everything, Wait blocks and no tokens refill. It does
+// nothing if the limiter is already paused.
+func (rl *RateLimiter) Pause() {
+	rl.lock()
+	defer rl.unlock()
+
+	if rl.paused || rl.closed {
+		return
+	}
+
+	now := rl.clock.Now()
+	rl.refill(now)
+	rl.paused = true
+	rl.pausedAt = now
+	if rl.ticker != nil {
+		rl.ticker.Stop()
+	}
+}
+
+// Resume ends a Pause and wakes the blocked Wait calls. The refill carries
+// on where it left off, as if the pause never happened, so resuming does not
+// grant the tokens the pause would have accrued.
+func (rl *RateLimiter) Resume() {
+	rl.lock()
+	defer rl.unlock()
+
+	if !rl.paused {
+		return
+	}
+
+	now := rl.clock.Now()
+	rl.paused = false
+	rl.lastRefill = rl.lastRefill.Add(now.Sub(rl.pausedAt))
+	if rl.ticker != nil && !rl.closed {
+		rl.resetTicker(max(rl.lastRefill.Add(rl.interval).Sub(now), 1))
+	}
+	rl.notify()
+}
+
+// Close stops the refill goroutine and releases the underlying ticker.
+// After Close, Use always returns false and Wait returns immediately.
+// Calling Close more than once is safe.
+func (rl *RateLimiter) Close() {
+	rl.closeOnce.Do(func() {
+		rl.lock()
+		defer rl.unlock()
+
+		rl.closed = true
+		if rl.ticker != nil {
+			rl.ticker.Stop()
+		}
+		close(rl.done)
+	})
 }
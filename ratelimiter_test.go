@@ -0,0 +1,1351 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// checkGoroutines records how many goroutines are running and returns a
+// func that fails t unless no more are running within a second, e.g. once
+// everything started in between was closed or cancelled.
+func checkGoroutines(t *testing.T) func() {
+	t.Helper()
+
+	before := runtime.NumGoroutine()
+	return func() {
+		t.Helper()
+		eventually(t, func() bool { return runtime.NumGoroutine() <= before })
+	}
+}
+
+func TestCloseStopsRefillGoroutine(t *testing.T) {
+	check := checkGoroutines(t)
+
+	rl := NewRateLimiterWithBurst(context.Background(), Options{BurstAmount: 1, Interval: time.Millisecond})
+	rl.Use()
+	rl.Close()
+	rl.Close()
+
+	if rl.Use() {
+		t.Fatal("Use allowed after Close")
+	}
+	if err := rl.Wait(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Wait after Close = %v, want ErrClosed", err)
+	}
+	check()
+}
+
+func TestConcurrentUse(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rl := NewRateLimiterWithBurst(ctx, Options{BurstAmount: 50, Interval: time.Hour})
+
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 10 {
+				if rl.Use() {
+					allowed.Add(1)
+				}
+				rl.CurrentBurst()
+				rl.Tokens()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := allowed.Load(); got != 50 {
+		t.Fatalf("%d uses allowed by a burst of 50", got)
+	}
+	if got := rl.Stats(); got.Allowed != 50 || got.Denied != 950 {
+		t.Fatalf("Allowed, Denied = %d, %d, want 50, 950", got.Allowed, got.Denied)
+	}
+}
+
+func TestIdleTickerStopsWhenFull(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	rl := newFakeLimiter(ctx, Options{BurstAmount: 2, Interval: 20 * time.Millisecond}, clk)
+
+	rl.Use()
+	clk.Advance(20 * time.Millisecond)
+
+	rl.mu.Lock()
+	idle := rl.idle
+	rl.mu.Unlock()
+	if !idle {
+		t.Fatal("ticker still running with a full bucket")
+	}
+
+	rl.Use()
+	clk.Advance(20 * time.Millisecond)
+	if got := rl.CurrentBurst(); got != 2 {
+		t.Fatalf("CurrentBurst one interval after Use = %d, want 2", got)
+	}
+}
+
+func TestSetBurstWakesIdleTicker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	rl := newFakeLimiter(ctx, Options{BurstAmount: 2, Interval: 20 * time.Millisecond}, clk)
+
+	rl.Use()
+	clk.Advance(20 * time.Millisecond)
+
+	rl.SetBurst(10)
+	clk.Advance(200 * time.Millisecond)
+	if got := rl.CurrentBurst(); got != 10 {
+		t.Fatalf("CurrentBurst after SetBurst(10) and 10 intervals = %d, want 10", got)
+	}
+
+	rl.Use()
+	clk.Advance(20 * time.Millisecond)
+	if err := rl.SetOptions(Options{BurstAmount: 12, Interval: 20 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(40 * time.Millisecond)
+	if got := rl.CurrentBurst(); got != 12 {
+		t.Fatalf("CurrentBurst after SetOptions with a burst of 12 = %d, want 12", got)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	clk := newFakeClock()
+	rl := newFakeLazyLimiter(Options{BurstAmount: 5, Interval: time.Second}, clk)
+	rl.UseN(2)
+
+	if got := rl.Drain(); got != 3 {
+		t.Fatalf("Drain with 3 tokens = %d, want 3", got)
+	}
+	if got := rl.CurrentBurst(); got != 0 {
+		t.Fatalf("CurrentBurst after Drain = %d, want 0", got)
+	}
+	if got := rl.Drain(); got != 0 {
+		t.Fatalf("Drain of an empty bucket = %d, want 0", got)
+	}
+
+	// A partial token is left behind.
+	clk.Advance(1500 * time.Millisecond)
+	if got := rl.Drain(); got != 1 {
+		t.Fatalf("Drain with 1.5 tokens = %d, want 1", got)
+	}
+	if got := rl.Tokens(); got != 0.5 {
+		t.Fatalf("Tokens after draining 1.5 = %v, want 0.5", got)
+	}
+}
+
+func TestDisabledDrain(t *testing.T) {
+	clk := newFakeClock()
+	rl := newFakeLazyLimiter(Options{BurstAmount: 3, Interval: time.Second, Disabled: true}, clk)
+
+	if got := rl.Drain(); got != 3 {
+		t.Fatalf("Drain on a disabled limiter = %d, want 3", got)
+	}
+	if !rl.CanUse() {
+		t.Fatal("CanUse false on a disabled limiter after Drain")
+	}
+	for i := range 10 {
+		if !rl.Use() {
+			t.Fatalf("Use %d denied on a disabled limiter", i)
+		}
+	}
+}
+
+// newBenchLimiter returns a limiter that refills faster than any caller can
+// use it, so that the benchmarks measure the cost of an allowed Use. With
+// lockOnly every Use takes rl.mu, as without the lock-free path.
+func newBenchLimiter(lockOnly bool) *RateLimiter {
+	rl := NewLazyRateLimiter(Options{BurstAmount: MaxAllowedBurst, Interval: time.Nanosecond})
+	rl.lockOnly = lockOnly
+	return rl
+}
+
+// benchPaths runs bench for the lock-free and the mutex path of Use.
+func benchPaths(b *testing.B, bench func(b *testing.B, rl *RateLimiter)) {
+	for _, path := range []string{"atomic", "mutex"} {
+		b.Run(path, func(b *testing.B) {
+			bench(b, newBenchLimiter(path == "mutex"))
+		})
+	}
+}
+
+func BenchmarkUse(b *testing.B) {
+	benchPaths(b, func(b *testing.B, rl *RateLimiter) {
+		for range b.N {
+			rl.Use()
+		}
+	})
+}
+
+func BenchmarkUseParallel(b *testing.B) {
+	benchPaths(b, func(b *testing.B, rl *RateLimiter) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				rl.Use()
+			}
+		})
+	})
+}
+
+func TestReconfigureConcurrentWithUse(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rl := NewRateLimiterWithBurst(ctx, Options{BurstAmount: 5, Interval: time.Millisecond})
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 200 {
+				switch i % 4 {
+				case 0:
+					rl.SetInterval(time.Duration(j%3+1) * time.Millisecond)
+				case 1:
+					rl.SetBurstInterval(time.Duration(j%2) * time.Microsecond)
+				case 2:
+					rl.Use()
+				default:
+					waitCtx, stop := context.WithTimeout(ctx, time.Millisecond)
+					rl.Wait(waitCtx)
+					stop()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The ticker is still running with whatever interval was set last.
+	rl.Drain()
+	eventually(t, func() bool { return rl.CurrentBurst() > 0 })
+}
+
+func TestC(t *testing.T) {
+	check := checkGoroutines(t)
+
+	rl := NewRateLimiterWithBurst(context.Background(), Options{BurstAmount: 2, Interval: time.Millisecond})
+	c := rl.C()
+	for i := range 5 {
+		select {
+		case <-c:
+		case <-time.After(time.Second):
+			t.Fatalf("no value %d from C", i)
+		}
+	}
+
+	rl.Close()
+	for range c {
+		// Drain a value sent before Close, if any.
+	}
+	check()
+}
+
+func TestWaitCancelledStress(t *testing.T) {
+	check := checkGoroutines(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rl := NewRateLimiterWithBurst(ctx, Options{BurstAmount: 5, Interval: 100 * time.Microsecond})
+
+	var wg sync.WaitGroup
+	var allowed atomic.Int64
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 200 {
+				waitCtx, waitCancel := context.WithTimeout(ctx, time.Duration((i+j)%20)*time.Microsecond)
+				err := rl.Wait(waitCtx)
+				waitCancel()
+				switch {
+				case err == nil:
+					allowed.Add(1)
+				case !errors.Is(err, ErrWaitTimeout):
+					t.Errorf("Wait = %v, want nil or ErrWaitTimeout", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every cancelled waiter left the queue and gave back what it held.
+	rl.mu.Lock()
+	queued, held := len(rl.queue), rl.held
+	rl.mu.Unlock()
+	if queued != 0 || held != 0 {
+		t.Fatalf("%d waiters left queued holding %v tokens", queued, held)
+	}
+	if got := int64(rl.Stats().Allowed); got != allowed.Load() {
+		t.Fatalf("Stats counted %d allowed uses, Wait returned nil %d times", got, allowed.Load())
+	}
+
+	cancel()
+	check()
+}
+
+func TestPauseResume(t *testing.T) {
+	for _, lazy := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		clk := newFakeClock()
+		start := clk.Now()
+		opts := Options{BurstAmount: 2, Interval: time.Second}
+		var rl *RateLimiter
+		if lazy {
+			rl = newFakeLazyLimiter(opts, clk)
+		} else {
+			rl = newFakeLimiter(ctx, opts, clk)
+		}
+		rl.Drain()
+		rl.Pause()
+
+		done := startWaiters(t, ctx, rl, 1)
+		clk.Advance(5 * time.Second)
+		if got := rl.CurrentBurst(); got != 0 {
+			t.Fatalf("lazy=%v: CurrentBurst after 5s paused = %d, want 0", lazy, got)
+		}
+		if rl.Use() {
+			t.Fatalf("lazy=%v: Use allowed while paused", lazy)
+		}
+		select {
+		case <-done:
+			t.Fatalf("lazy=%v: Wait returned while paused", lazy)
+		default:
+		}
+
+		// The refill carries on from where it was paused, so the waiter
+		// gets the first token a second after resuming, and nobody gets
+		// the tokens the pause would have accrued.
+		rl.Resume()
+		if lazy {
+			awaitTimer(t, clk, start.Add(6*time.Second))
+		}
+		clk.Advance(time.Second)
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("lazy=%v: Wait still blocked after Resume", lazy)
+		}
+		if rl.Use() {
+			t.Fatalf("lazy=%v: Use allowed a token accrued during the pause", lazy)
+		}
+		cancel()
+	}
+}
+
+func TestJitterBounds(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	rl := newRateLimiter(Options{BurstAmount: 100, Interval: 100 * time.Millisecond, Jitter: 0.5}, clk)
+	rl.rand = rand.New(rand.NewSource(1))
+	rl.start(ctx)
+	rl.Drain()
+
+	// nextTick returns how long until the refill ticker fires.
+	nextTick := func() time.Duration {
+		clk.mu.Lock()
+		defer clk.mu.Unlock()
+
+		return clk.tickers[0].next.Sub(clk.now)
+	}
+
+	gaps := make(map[time.Duration]bool)
+	for i := range 50 {
+		gap := nextTick()
+		if gap < 50*time.Millisecond || gap > 150*time.Millisecond {
+			t.Fatalf("refill %d due %v after the previous one, want within 100ms ±50%%", i, gap)
+		}
+		gaps[gap] = true
+
+		clk.Advance(gap)
+		if got := rl.CurrentBurst(); got != i+1 {
+			t.Fatalf("CurrentBurst after refill %d = %d, want %d", i, got, i+1)
+		}
+	}
+	if len(gaps) < 40 {
+		t.Fatalf("only %d distinct gaps between 50 refills, want them randomized", len(gaps))
+	}
+}
+
+func TestWaitWithJitter(t *testing.T) {
+	clk := newFakeClock()
+	rl := newFakeLazyLimiter(Options{BurstAmount: 20, Interval: time.Hour}, clk)
+	rl.rand = rand.New(rand.NewSource(1))
+
+	// jitterTimer waits for the timer of the jitter sleep and returns how
+	// long it sleeps for.
+	jitterTimer := func() time.Duration {
+		var d time.Duration
+		eventually(t, func() bool {
+			clk.mu.Lock()
+			defer clk.mu.Unlock()
+
+			for _, timer := range clk.timers {
+				if timer.running {
+					d = timer.at.Sub(clk.now)
+					return true
+				}
+			}
+			return false
+		})
+		return d
+	}
+
+	const maxJitter = 100 * time.Millisecond
+	for i := range 10 {
+		errs := make(chan error, 1)
+		go func() { errs <- rl.WaitWithJitter(context.Background(), maxJitter) }()
+
+		d := jitterTimer()
+		if d < 0 || d > maxJitter {
+			t.Fatalf("call %d sleeps %v, want within [0, %v]", i, d, maxJitter)
+		}
+		clk.Advance(d)
+		if err := <-errs; err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+	}
+
+	// Cancelling during the sleep returns the context error, but the token
+	// stays spent.
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() { errs <- rl.WaitWithJitter(ctx, time.Hour) }()
+	jitterTimer()
+	cancel()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Fatalf("WaitWithJitter cancelled during the sleep = %v, want context.Canceled", err)
+	}
+	if got := rl.CurrentBurst(); got != 9 {
+		t.Fatalf("CurrentBurst after 11 calls = %d, want 9", got)
+	}
+}
+
+func TestRefund(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rl := newFakeLimiter(ctx, Options{BurstAmount: 5, Interval: time.Hour}, newFakeClock())
+	rl.UseN(3)
+	rl.Refund(2)
+	if got := rl.CurrentBurst(); got != 4 {
+		t.Fatalf("CurrentBurst after using 3 and refunding 2 = %d, want 4", got)
+	}
+	rl.Refund(10)
+	if got := rl.CurrentBurst(); got != 5 {
+		t.Fatalf("CurrentBurst after refunding past the burst = %d, want MaxBurst 5", got)
+	}
+	rl.Refund(-1)
+	if got := rl.CurrentBurst(); got != 5 {
+		t.Fatalf("CurrentBurst after a negative refund = %d, want 5", got)
+	}
+
+	// Refilling takes an hour, so only the refund can unblock the waiter.
+	rl.Drain()
+	done := startWaiters(t, ctx, rl, 1)
+	rl.Refund(1)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Wait still blocked after a refund")
+	}
+}
+
+func TestResetBurstWakesWaiter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rl := newFakeLimiter(ctx, Options{BurstAmount: 3, Interval: time.Hour}, newFakeClock())
+	rl.Drain()
+	done := startWaiters(t, ctx, rl, 2)
+
+	rl.ResetBurst()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Wait still blocked after ResetBurst")
+	}
+	if got := rl.CurrentBurst(); got != 1 {
+		t.Fatalf("CurrentBurst after the waiter took 2 = %d, want 1", got)
+	}
+}
+
+func TestWaitContextErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rl := NewRateLimiterWithBurst(ctx, Options{BurstAmount: 1, Interval: time.Hour})
+	rl.Drain()
+
+	deadline, stop := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer stop()
+	err := rl.Wait(deadline)
+	if !errors.Is(err, ErrWaitTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait past its deadline = %v, want ErrWaitTimeout and context.DeadlineExceeded", err)
+	}
+	if errors.Is(err, context.Canceled) {
+		t.Fatalf("Wait past its deadline = %v, which also matches context.Canceled", err)
+	}
+
+	cancelled, cancelWait := context.WithCancel(ctx)
+	errs := make(chan error, 1)
+	go func() { errs <- rl.Wait(cancelled) }()
+	eventually(t, func() bool { return rl.Stats().WaitersBlocked == 1 })
+	cancelWait()
+	err = <-errs
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled Wait = %v, want context.Canceled", err)
+	}
+	if errors.Is(err, ErrWaitTimeout) || errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("cancelled Wait = %v, which also matches a timeout", err)
+	}
+}
+
+func TestClone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opts := Options{BurstAmount: 3, Interval: time.Hour, BurstInterval: time.Millisecond, RefillAmount: 2, Jitter: 0.1}
+	rl := NewRateLimiterWithBurst(ctx, opts)
+	rl.UseN(2)
+
+	clone := rl.Clone(ctx)
+	defer clone.Close()
+	if got, want := clone.options(), rl.options(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("clone options = %+v, want %+v", got, want)
+	}
+	if got := clone.CurrentBurst(); got != 3 {
+		t.Fatalf("clone CurrentBurst = %d, want a full bucket of 3", got)
+	}
+
+	// Using or reconfiguring one leaves the other alone.
+	clone.Drain()
+	if got := rl.CurrentBurst(); got != 1 {
+		t.Fatalf("CurrentBurst after draining the clone = %d, want 1", got)
+	}
+	rl.SetBurst(10)
+	if got := clone.MaxBurst(); got != 3 {
+		t.Fatalf("clone MaxBurst after SetBurst on the original = %d, want 3", got)
+	}
+	rl.Close()
+	if clone.Use() {
+		t.Fatal("drained clone allowed a Use")
+	}
+	timeout, stop := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer stop()
+	if err := clone.Wait(timeout); !errors.Is(err, ErrWaitTimeout) {
+		t.Fatalf("Wait on the clone after closing the original = %v, want ErrWaitTimeout", err)
+	}
+}
+
+func TestWait(t *testing.T) {
+	check := checkGoroutines(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rl := NewRateLimiterWithBurst(ctx, Options{BurstAmount: 1, Interval: 20 * time.Millisecond})
+
+	if err := rl.Wait(ctx); err != nil {
+		t.Fatalf("Wait with a token available = %v, want nil", err)
+	}
+	start := time.Now()
+	if err := rl.Wait(ctx); err != nil {
+		t.Fatalf("Wait for a refill = %v, want nil", err)
+	}
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Fatalf("Wait for a refill returned after %v, before the refill", elapsed)
+	}
+
+	// A timeout shorter than the refill gives up before the token.
+	timeout, stop := context.WithTimeout(ctx, time.Millisecond)
+	defer stop()
+	if err := rl.Wait(timeout); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait with a short timeout = %v, want context.DeadlineExceeded", err)
+	}
+	if got := rl.Stats().WaitersBlocked; got != 0 {
+		t.Fatalf("WaitersBlocked after the timeout = %d, want 0", got)
+	}
+
+	cancel()
+	check()
+}
+
+func TestTryUse(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rl := NewRateLimiterWithBurst(ctx, Options{BurstAmount: 1, Interval: 20 * time.Millisecond})
+	if !rl.TryUse(time.Millisecond) {
+		t.Fatal("TryUse with a token available failed")
+	}
+	if !rl.TryUse(time.Second) {
+		t.Fatal("TryUse with a refill within the timeout failed")
+	}
+
+	if rl.TryUse(time.Millisecond) {
+		t.Fatal("TryUse with no refill within the timeout succeeded")
+	}
+}
+
+func TestUseN(t *testing.T) {
+	rl := newFakeLazyLimiter(Options{BurstAmount: 5, Interval: time.Hour}, newFakeClock())
+
+	if !rl.UseN(1) {
+		t.Fatal("UseN(1) on a full bucket failed")
+	}
+	if got := rl.CurrentBurst(); got != 4 {
+		t.Fatalf("CurrentBurst after UseN(1) = %d, want 4", got)
+	}
+	if rl.UseN(5) {
+		t.Fatal("UseN(5) with 4 tokens succeeded")
+	}
+	if got := rl.CurrentBurst(); got != 4 {
+		t.Fatalf("CurrentBurst after a failed UseN = %d, want it untouched at 4", got)
+	}
+	if !rl.UseN(4) {
+		t.Fatal("UseN of exactly CurrentBurst failed")
+	}
+	if got := rl.CurrentBurst(); got != 0 {
+		t.Fatalf("CurrentBurst after UseN(4) = %d, want 0", got)
+	}
+
+	rl.ResetBurst()
+	if rl.UseN(6) {
+		t.Fatal("UseN above MaxBurst succeeded")
+	}
+	if got := rl.CurrentBurst(); got != 5 {
+		t.Fatalf("CurrentBurst after UseN above MaxBurst = %d, want it untouched at 5", got)
+	}
+}
+
+func TestWaitN(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	rl := newFakeLimiter(ctx, Options{BurstAmount: 5, Interval: 10 * time.Millisecond}, clk)
+	rl.Drain()
+
+	done := startWaiters(t, ctx, rl, 3)
+	for i := range 2 {
+		clk.Advance(10 * time.Millisecond)
+		select {
+		case <-done:
+			t.Fatalf("WaitN(3) returned after %d refills", i+1)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	clk.Advance(10 * time.Millisecond)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("WaitN(3) still blocked after 3 refills")
+	}
+	if got := rl.CurrentBurst(); got != 0 {
+		t.Fatalf("CurrentBurst after WaitN(3) = %d, want 0", got)
+	}
+
+	if err := rl.WaitN(ctx, 6); !errors.Is(err, ErrExceedsBurst) {
+		t.Fatalf("WaitN above MaxBurst = %v, want ErrExceedsBurst", err)
+	}
+}
+
+func TestTokensIncreaseBetweenUses(t *testing.T) {
+	for _, lazy := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		clk := newFakeClock()
+		opts := Options{BurstAmount: 3, Interval: time.Second}
+		var rl *RateLimiter
+		if lazy {
+			rl = newFakeLazyLimiter(opts, clk)
+		} else {
+			rl = newFakeLimiter(ctx, opts, clk)
+		}
+		rl.Drain()
+
+		prev := rl.Tokens()
+		for i := range 40 {
+			clk.Advance(100 * time.Millisecond)
+			got := rl.Tokens()
+			switch {
+			case got > 3:
+				t.Fatalf("lazy=%v: Tokens after %d steps = %v, above MaxBurst", lazy, i+1, got)
+			case got < prev || got == prev && got < 3:
+				t.Fatalf("lazy=%v: Tokens went from %v to %v without a use", lazy, prev, got)
+			}
+			prev = got
+		}
+		if prev != 3 {
+			t.Fatalf("lazy=%v: Tokens after 4s = %v, want a full bucket", lazy, prev)
+		}
+		cancel()
+	}
+}
+
+func TestAllowMatchesUse(t *testing.T) {
+	clk := newFakeClock()
+	opts := Options{BurstAmount: 4, Interval: 100 * time.Millisecond, BurstInterval: 10 * time.Millisecond}
+	use, allow := newFakeLazyLimiter(opts, clk), newFakeLazyLimiter(opts, clk)
+
+	// A sequence of calls hitting the cooldown, the burst and refills.
+	for i, n := range []int{1, 1, 2, 5, 3, 1, 0, 4, 2, 1, 1, 1} {
+		var used, allowed bool
+		if n == 1 {
+			used, allowed = use.Use(), allow.Allow()
+		} else {
+			used, allowed = use.UseN(n), allow.AllowN(n)
+		}
+		if used != allowed {
+			t.Fatalf("call %d with n=%d: Use reported %v, Allow %v", i, n, used, allowed)
+		}
+		if a, b := use.Tokens(), allow.Tokens(); a != b {
+			t.Fatalf("call %d with n=%d: Tokens %v after Use, %v after Allow", i, n, a, b)
+		}
+		clk.Advance(time.Duration(i%3) * 5 * time.Millisecond)
+	}
+	if a, b := use.Stats(), allow.Stats(); a != b {
+		t.Fatalf("Stats after Use %+v, after Allow %+v", a, b)
+	}
+}
+
+func TestWaitDeadline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rl := NewRateLimiterWithBurst(ctx, Options{BurstAmount: 1, Interval: 20 * time.Millisecond})
+	rl.Drain()
+
+	start := time.Now()
+	if err := rl.WaitDeadline(start.Add(-time.Second)); !errors.Is(err, ErrWaitTimeout) {
+		t.Fatalf("WaitDeadline in the past = %v, want ErrWaitTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed >= 20*time.Millisecond {
+		t.Fatalf("WaitDeadline in the past blocked for %v", elapsed)
+	}
+
+	if err := rl.WaitDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("WaitDeadline leaving time for a refill = %v, want nil", err)
+	}
+}
+
+func TestSetIntervalKeepsProgress(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	rl := newFakeLimiter(ctx, Options{BurstAmount: 5, Interval: time.Second}, clk)
+	rl.Drain()
+
+	// Halfway to the refill, the longer interval counts from the last one.
+	clk.Advance(500 * time.Millisecond)
+	rl.SetInterval(2 * time.Second)
+	clk.Advance(1499 * time.Millisecond)
+	if got := rl.CurrentBurst(); got != 0 {
+		t.Fatalf("CurrentBurst just before 2s = %d, want 0", got)
+	}
+	clk.Advance(time.Millisecond)
+	if got := rl.CurrentBurst(); got != 1 {
+		t.Fatalf("CurrentBurst at 2s = %d, want 1", got)
+	}
+
+	// A shorter interval that has already passed refills at once.
+	clk.Advance(500 * time.Millisecond)
+	rl.SetInterval(300 * time.Millisecond)
+	if got := rl.CurrentBurst(); got != 2 {
+		t.Fatalf("CurrentBurst after shortening the interval past due = %d, want 2", got)
+	}
+	clk.Advance(300 * time.Millisecond)
+	if got := rl.CurrentBurst(); got != 3 {
+		t.Fatalf("CurrentBurst a new interval later = %d, want 3", got)
+	}
+}
+
+func TestCanUsePredictsUse(t *testing.T) {
+	clk := newFakeClock()
+	rl := newFakeLazyLimiter(Options{BurstAmount: 2, Interval: 100 * time.Millisecond, BurstInterval: 10 * time.Millisecond}, clk)
+
+	for i, step := range []struct {
+		advance time.Duration
+		want    bool
+	}{
+		{0, true},
+		{5 * time.Millisecond, false},  // in the cooldown
+		{5 * time.Millisecond, true},   // the cooldown elapsed
+		{5 * time.Millisecond, false},  // in the cooldown and exhausted
+		{50 * time.Millisecond, false}, // exhausted
+		{50 * time.Millisecond, true},  // refilled
+	} {
+		clk.Advance(step.advance)
+		if got := rl.CanUse(); got != step.want {
+			t.Fatalf("step %d: CanUse = %v, want %v", i, got, step.want)
+		}
+		if again := rl.CanUse(); again != step.want {
+			t.Fatalf("step %d: second CanUse = %v, want it to consume nothing", i, again)
+		}
+		if got := rl.Use(); got != step.want {
+			t.Fatalf("step %d: Use = %v after CanUse reported %v", i, got, step.want)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	clk := newFakeClock()
+	rl := newFakeLazyLimiter(Options{BurstAmount: 10, Interval: time.Second, BurstInterval: 100 * time.Millisecond}, clk)
+	rl.UseN(7)
+	clk.Advance(57 * time.Millisecond)
+
+	want := "RateLimiter{burst=3/10 interval=1s burstInterval=100ms cooldownIn=43ms}"
+	if got := rl.String(); got != want {
+		t.Fatalf("String = %q, want %q", got, want)
+	}
+}
+
+func TestNewRateLimiterWithBurstChecked(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	valid := Options{BurstAmount: 5, Interval: time.Second}
+	negative := -1
+	tooMany := 6
+	for field, change := range map[string]func(*Options){
+		"BurstAmount":      func(o *Options) { o.BurstAmount = 0 },
+		"BurstAmount max":  func(o *Options) { o.BurstAmount = MaxAllowedBurst + 1 },
+		"Interval":         func(o *Options) { o.Interval = 0 },
+		"BurstInterval":    func(o *Options) { o.BurstInterval = -time.Second },
+		"RefillAmount":     func(o *Options) { o.RefillAmount = -1 },
+		"Jitter":           func(o *Options) { o.Jitter = 1.5 },
+		"InitialBurst":     func(o *Options) { o.InitialBurst = &negative },
+		"InitialBurst max": func(o *Options) { o.InitialBurst = &tooMany },
+	} {
+		opts := valid
+		change(&opts)
+		rl, err := NewRateLimiterWithBurstChecked(ctx, opts)
+		if !errors.Is(err, ErrInvalidOptions) || rl != nil {
+			t.Errorf("invalid %s: got %v, %v, want nil, ErrInvalidOptions", field, rl, err)
+			continue
+		}
+		if name := strings.Fields(field)[0]; !strings.Contains(err.Error(), name) {
+			t.Errorf("invalid %s: error %q does not name the field", field, err)
+		}
+	}
+
+	rl, err := NewRateLimiterWithBurstChecked(ctx, valid)
+	if err != nil {
+		t.Fatalf("valid options: %v", err)
+	}
+	rl.Close()
+}
+
+func TestResetAfterDrain(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	rl := newFakeLimiter(ctx, Options{BurstAmount: 3, Interval: time.Hour, BurstInterval: time.Hour}, clk)
+	rl.Drain()
+	clk.Advance(time.Minute)
+	if rl.Use() {
+		t.Fatal("Use after Drain succeeded")
+	}
+
+	rl.Reset()
+	if !rl.Use() {
+		t.Fatal("Use after Reset failed despite the long burst interval")
+	}
+	if got := rl.CurrentBurst(); got != 2 {
+		t.Fatalf("CurrentBurst after Reset and a Use = %d, want 2", got)
+	}
+	if rl.Use() {
+		t.Fatal("second Use after Reset succeeded within the burst interval")
+	}
+}
+
+func TestUseContext(t *testing.T) {
+	clk := newFakeClock()
+	rl := newFakeLazyLimiter(Options{BurstAmount: 2, Interval: time.Hour, BurstInterval: 50 * time.Millisecond}, clk)
+	ctx := context.Background()
+
+	if ok, err := rl.UseContext(ctx); !ok || err != nil {
+		t.Fatalf("UseContext with a token available = %v, %v, want true, nil", ok, err)
+	}
+
+	// The second token is there, so UseContext waits out the cooldown.
+	type result struct {
+		ok  bool
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		ok, err := rl.UseContext(ctx)
+		done <- result{ok, err}
+	}()
+	awaitTimer(t, clk, clk.Now().Add(50*time.Millisecond))
+	clk.Advance(50 * time.Millisecond)
+	if res := <-done; !res.ok || res.err != nil {
+		t.Fatalf("UseContext in the cooldown = %v, %v, want true, nil once it elapsed", res.ok, res.err)
+	}
+
+	// Without a token it never waits.
+	if ok, err := rl.UseContext(ctx); ok || err != nil {
+		t.Fatalf("UseContext with no token = %v, %v, want false, nil", ok, err)
+	}
+
+	rl.Refund(1)
+	cancelled, cancel := context.WithCancel(ctx)
+	go func() {
+		ok, err := rl.UseContext(cancelled)
+		done <- result{ok, err}
+	}()
+	awaitTimer(t, clk, clk.Now().Add(50*time.Millisecond))
+	cancel()
+	if res := <-done; res.ok || !errors.Is(res.err, context.Canceled) {
+		t.Fatalf("UseContext cancelled in the cooldown = %v, %v, want false, context.Canceled", res.ok, res.err)
+	}
+}
+
+func TestFractionalRefill(t *testing.T) {
+	for _, lazy := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		clk := newFakeClock()
+		opts := Options{BurstAmount: 20, Interval: time.Second, RefillAmount: 2.5}
+		var rl *RateLimiter
+		if lazy {
+			rl = newFakeLazyLimiter(opts, clk)
+		} else {
+			rl = newFakeLimiter(ctx, opts, clk)
+		}
+		rl.Drain()
+
+		clk.Advance(time.Second)
+		if got := rl.CurrentBurst(); got != 2 {
+			t.Fatalf("lazy=%v: CurrentBurst after 1s at 2.5/s = %d, want 2", lazy, got)
+		}
+		clk.Advance(3 * time.Second)
+		if got := rl.CurrentBurst(); got != 10 {
+			t.Fatalf("lazy=%v: CurrentBurst after 4s at 2.5/s = %d, want 10", lazy, got)
+		}
+		if !rl.UseN(10) || rl.Use() {
+			t.Fatalf("lazy=%v: 4s at 2.5/s did not allow exactly 10 uses", lazy)
+		}
+		cancel()
+	}
+}
+
+func TestAllowAtFutureTimes(t *testing.T) {
+	clk := newFakeClock()
+	rl := newFakeLazyLimiter(Options{BurstAmount: 5, Interval: 100 * time.Millisecond, BurstInterval: 10 * time.Millisecond}, clk)
+	now := clk.Now()
+	rl.UseN(4)
+
+	for _, c := range []struct {
+		n    int
+		at   time.Duration
+		want bool
+	}{
+		{1, 5 * time.Millisecond, false}, // in the cooldown
+		{1, 10 * time.Millisecond, true},
+		{2, 50 * time.Millisecond, false},
+		{2, 100 * time.Millisecond, true},
+		{3, 199 * time.Millisecond, false},
+		{3, 200 * time.Millisecond, true},
+		{5, 400 * time.Millisecond, true},
+		{6, time.Hour, false},  // more than MaxBurst
+		{1, -time.Hour, false}, // evaluated as now, in the cooldown
+	} {
+		if got := rl.AllowAt(c.n, now.Add(c.at)); got != c.want {
+			t.Errorf("AllowAt(%d, %v from now) = %v, want %v", c.n, c.at, got, c.want)
+		}
+	}
+	if got := rl.Tokens(); got != 1 {
+		t.Fatalf("Tokens after the AllowAt calls = %v, want them to consume nothing", got)
+	}
+}
+
+func TestUsableBurst(t *testing.T) {
+	clk := newFakeClock()
+	rl := newFakeLazyLimiter(Options{BurstAmount: 5, Interval: time.Hour, BurstInterval: time.Minute}, clk)
+
+	if got := rl.UsableBurst(); got != 5 {
+		t.Fatalf("UsableBurst of a full bucket = %d, want 5", got)
+	}
+	rl.Use()
+	if got := rl.CurrentBurst(); got != 4 {
+		t.Fatalf("CurrentBurst in the cooldown = %d, want 4", got)
+	}
+	if got := rl.UsableBurst(); got != 0 {
+		t.Fatalf("UsableBurst in the cooldown = %d, want 0", got)
+	}
+	clk.Advance(time.Minute)
+	if got := rl.UsableBurst(); got != 4 {
+		t.Fatalf("UsableBurst after the cooldown = %d, want 4", got)
+	}
+	rl.Pause()
+	if got := rl.UsableBurst(); got != 0 {
+		t.Fatalf("UsableBurst while paused = %d, want 0", got)
+	}
+}
+
+func TestOnFullFiresOnce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	rl := newFakeLimiter(ctx, Options{BurstAmount: 3, Interval: time.Second}, clk)
+	var fills atomic.Int64
+	rl.SetOnFull(func() { fills.Add(1) })
+
+	// The refill goroutine calls OnFull once it has stopped the ticker, so
+	// it may still be running when Advance returns.
+	rl.Use()
+	clk.Advance(time.Second)
+	eventually(t, func() bool { return fills.Load() == 1 })
+	clk.Advance(5 * time.Second)
+	if got := fills.Load(); got != 1 {
+		t.Fatalf("OnFull fired %d times while staying full, want 1", got)
+	}
+
+	// Another fill-up is another transition.
+	rl.UseN(2)
+	clk.Advance(time.Second)
+	if got := fills.Load(); got != 1 {
+		t.Fatalf("OnFull fired %d times halfway to full, want still 1", got)
+	}
+	clk.Advance(time.Second)
+	eventually(t, func() bool { return fills.Load() == 2 })
+}
+
+func TestSetBurstAndFill(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rl := newFakeLimiter(ctx, Options{BurstAmount: 5, Interval: time.Hour}, newFakeClock())
+	rl.UseN(3)
+	rl.SetBurstAndFill(20)
+	if got := rl.CurrentBurst(); got != 20 {
+		t.Fatalf("CurrentBurst after SetBurstAndFill(20) = %d, want 20", got)
+	}
+	if got := rl.MaxBurst(); got != 20 {
+		t.Fatalf("MaxBurst after SetBurstAndFill(20) = %d, want 20", got)
+	}
+
+	rl.SetBurstAndFill(4)
+	if got := rl.CurrentBurst(); got != 4 {
+		t.Fatalf("CurrentBurst after lowering with SetBurstAndFill(4) = %d, want 4", got)
+	}
+}
+
+func TestBurstClamping(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, c := range []struct {
+		burst, want int
+	}{
+		{math.MaxInt, MaxAllowedBurst},
+		{math.MinInt + 1, 1},
+		{-1 << 40, 1},
+		{0, 1},
+	} {
+		rl := NewRateLimiterWithBurst(ctx, Options{BurstAmount: c.burst, Interval: time.Hour})
+		if got := rl.MaxBurst(); got != c.want {
+			t.Errorf("MaxBurst with BurstAmount %d = %d, want %d", c.burst, got, c.want)
+		}
+		if got := rl.CurrentBurst(); got != c.want {
+			t.Errorf("CurrentBurst with BurstAmount %d = %d, want %d", c.burst, got, c.want)
+		}
+		rl.SetBurst(c.burst)
+		if got := rl.MaxBurst(); got != c.want {
+			t.Errorf("MaxBurst after SetBurst(%d) = %d, want %d", c.burst, got, c.want)
+		}
+		rl.Close()
+	}
+}
+
+func TestUseCost(t *testing.T) {
+	rl := newFakeLazyLimiter(Options{BurstAmount: 10, Interval: time.Hour}, newFakeClock())
+	if !rl.UseCost() || rl.CurrentBurst() != 9 {
+		t.Fatalf("UseCost without a cost func left %d tokens, want 9", rl.CurrentBurst())
+	}
+
+	costs := []int{4, 2, 5, 3}
+	rl.SetCostFunc(func() int {
+		n := costs[0]
+		costs = costs[1:]
+		return n
+	})
+	for _, c := range []struct {
+		ok   bool
+		left int
+	}{
+		{true, 5},  // cost 4
+		{true, 3},  // cost 2
+		{false, 3}, // cost 5, more than is left
+		{true, 0},  // cost 3
+	} {
+		if got := rl.UseCost(); got != c.ok {
+			t.Fatalf("UseCost with %d tokens left = %v, want %v", rl.CurrentBurst(), got, c.ok)
+		}
+		if got := rl.CurrentBurst(); got != c.left {
+			t.Fatalf("CurrentBurst after UseCost = %d, want %d", got, c.left)
+		}
+	}
+
+	rl.SetCostFunc(nil)
+	rl.Reset()
+	if !rl.UseCost() || rl.CurrentBurst() != 9 {
+		t.Fatalf("UseCost after removing the cost func left %d tokens, want 9", rl.CurrentBurst())
+	}
+}
+
+func TestEffectiveRateMatchesThroughput(t *testing.T) {
+	for _, opts := range []Options{
+		{BurstAmount: 5, Interval: time.Second, RefillAmount: 2},
+		{BurstAmount: 5, Interval: time.Second, RefillAmount: 2, BurstInterval: time.Second},
+		{BurstAmount: 5, Interval: 500 * time.Millisecond, RefillAmount: 1, BurstInterval: 250 * time.Millisecond},
+	} {
+		clk := newFakeClock()
+		rl := newFakeLazyLimiter(opts, clk)
+		rl.Drain()
+
+		// Use tokens as fast as the limiter lets through for ten seconds,
+		// once the initial burst is gone.
+		uses := 0
+		for range 1000 {
+			clk.Advance(10 * time.Millisecond)
+			for rl.Use() {
+				uses++
+			}
+		}
+		want := rl.EffectiveRate() * 10
+		if math.Abs(float64(uses)-want) > 1 {
+			t.Errorf("%+v: %d uses in 10s, EffectiveRate promises %v", opts, uses, want)
+		}
+	}
+}
+
+func TestWaitTimed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	rl := newFakeLimiter(ctx, Options{BurstAmount: 1, Interval: time.Second}, clk)
+	if d, err := rl.WaitTimed(ctx); err != nil || d != 0 {
+		t.Fatalf("WaitTimed with a token available = %v, %v, want 0, nil", d, err)
+	}
+
+	type result struct {
+		d   time.Duration
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		d, err := rl.WaitTimed(ctx)
+		done <- result{d, err}
+	}()
+	eventually(t, func() bool { return rl.Stats().WaitersBlocked == 1 })
+	clk.Advance(time.Second)
+	if r := <-done; r.err != nil || r.d != time.Second {
+		t.Fatalf("WaitTimed across a refill = %v, %v, want %v, nil", r.d, r.err, time.Second)
+	}
+}
+
+func TestSetLimitRoundTrip(t *testing.T) {
+	rl := newFakeLazyLimiter(Options{BurstAmount: 10, Interval: time.Second, RefillAmount: 3}, newFakeClock())
+	for _, limit := range []float64{0.001, 0.5, 1, 3, 7.3, 1000, 1e6} {
+		rl.SetLimit(limit)
+		if got := rl.Limit(); math.Abs(got-limit) > limit*1e-6 {
+			t.Errorf("Limit after SetLimit(%v) = %v", limit, got)
+		}
+
+		// Feeding Limit back in must not drift the interval.
+		interval := rl.Interval()
+		for range 10 {
+			rl.SetLimit(rl.Limit())
+		}
+		if got := rl.Interval(); got != interval {
+			t.Errorf("Interval after repeating SetLimit(%v) = %v, want %v", limit, got, interval)
+		}
+	}
+
+	rl.SetLimit(0)
+	if got := rl.Interval(); got != time.Second {
+		t.Fatalf("Interval after SetLimit(0) = %v, want 1s", got)
+	}
+}
+
+func TestUseBlockingCooldown(t *testing.T) {
+	clk := newFakeClock()
+	rl := newFakeLazyLimiter(Options{BurstAmount: 2, Interval: time.Hour, BurstInterval: 50 * time.Millisecond}, clk)
+	if !rl.UseBlockingCooldown() {
+		t.Fatal("UseBlockingCooldown with a full bucket failed")
+	}
+
+	// The second token is there, so it blocks until the cooldown elapses.
+	done := make(chan bool, 1)
+	go func() { done <- rl.UseBlockingCooldown() }()
+	awaitTimer(t, clk, clk.Now().Add(50*time.Millisecond))
+	select {
+	case <-done:
+		t.Fatal("UseBlockingCooldown returned before the cooldown elapsed")
+	default:
+	}
+	clk.Advance(50 * time.Millisecond)
+	if !<-done {
+		t.Fatal("UseBlockingCooldown failed once the cooldown elapsed")
+	}
+	if got := rl.CurrentBurst(); got != 0 {
+		t.Fatalf("CurrentBurst after two uses = %d, want 0", got)
+	}
+
+	// Without a token it fails straight away.
+	clk.Advance(50 * time.Millisecond)
+	if rl.UseBlockingCooldown() {
+		t.Fatal("UseBlockingCooldown with an empty bucket succeeded")
+	}
+}
+
+func TestUseNOrErr(t *testing.T) {
+	rl := newFakeLazyLimiter(Options{BurstAmount: 3, Interval: time.Hour}, newFakeClock())
+	for _, c := range []struct {
+		n    int
+		want error
+	}{
+		{2, nil},
+		{2, ErrWouldBlock},
+		{4, ErrExceedsBurst},
+		{1, nil},
+	} {
+		if err := rl.UseNOrErr(c.n); !errors.Is(err, c.want) || (c.want == nil && err != nil) {
+			t.Fatalf("UseNOrErr(%d) = %v, want %v", c.n, err, c.want)
+		}
+	}
+
+	rl.Close()
+	if err := rl.UseNOrErr(1); !errors.Is(err, ErrClosed) {
+		t.Fatalf("UseNOrErr on a closed limiter = %v, want ErrClosed", err)
+	}
+}
+
+func TestTimeToNext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	rl := newFakeLimiter(ctx, Options{BurstAmount: 2, Interval: time.Second, BurstInterval: 300 * time.Millisecond}, clk)
+	if got := rl.TimeToNext(); got != 0 {
+		t.Fatalf("TimeToNext with a usable token = %v, want 0", got)
+	}
+
+	// A token is left but the cooldown holds it back.
+	rl.Use()
+	if got := rl.TimeToNext(); got != 300*time.Millisecond {
+		t.Fatalf("TimeToNext in the cooldown = %v, want 300ms", got)
+	}
+
+	// The bucket is empty and the next tick is further away than the
+	// cooldown.
+	clk.Advance(300 * time.Millisecond)
+	if !rl.Use() {
+		t.Fatal("Use after the cooldown failed")
+	}
+	if got := rl.TimeToNext(); got != 700*time.Millisecond {
+		t.Fatalf("TimeToNext with an empty bucket = %v, want 700ms", got)
+	}
+	clk.Advance(700 * time.Millisecond)
+	eventually(t, func() bool { return rl.TimeToNext() == 0 })
+	if !rl.Use() {
+		t.Fatal("Use once TimeToNext reached 0 failed")
+	}
+}
+
+func TestInitialBurstZero(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	zero := 0
+	opts := Options{BurstAmount: 3, Interval: time.Second, RefillAmount: 1, InitialBurst: &zero}
+	for _, lazy := range []bool{false, true} {
+		clk := newFakeClock()
+		var rl *RateLimiter
+		if lazy {
+			rl = newFakeLazyLimiter(opts, clk)
+		} else {
+			rl = newFakeLimiter(ctx, opts, clk)
+		}
+
+		if rl.Use() {
+			t.Fatalf("lazy %v: Use before the first refill succeeded", lazy)
+		}
+		clk.Advance(999 * time.Millisecond)
+		if rl.Use() {
+			t.Fatalf("lazy %v: Use just before the first refill succeeded", lazy)
+		}
+		clk.Advance(time.Millisecond)
+		eventually(t, func() bool { return rl.CurrentBurst() == 1 })
+		if !rl.Use() {
+			t.Fatalf("lazy %v: Use after the first refill failed", lazy)
+		}
+		if rl.MaxBurst() != 3 {
+			t.Fatalf("lazy %v: MaxBurst = %d, want 3", lazy, rl.MaxBurst())
+		}
+	}
+}
+
+func TestSetOptionsLowerRate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	high := Options{BurstAmount: 100, Interval: 100 * time.Millisecond, RefillAmount: 100}
+	low := Options{BurstAmount: 5, Interval: time.Second, RefillAmount: 1}
+	for _, lazy := range []bool{false, true} {
+		clk := newFakeClock()
+		var rl *RateLimiter
+		if lazy {
+			rl = newFakeLazyLimiter(high, clk)
+		} else {
+			rl = newFakeLimiter(ctx, high, clk)
+		}
+		rl.UseN(50)
+		clk.Advance(50 * time.Millisecond)
+		if err := rl.SetOptions(low); err != nil {
+			t.Fatal(err)
+		}
+
+		// The tokens left from the high rate shrink to the new burst, and
+		// from then on only the low rate refills the bucket.
+		uses := 0
+		for range 100 {
+			for rl.Use() {
+				uses++
+			}
+			clk.Advance(100 * time.Millisecond)
+		}
+		if uses < low.BurstAmount || uses > low.BurstAmount+10 {
+			t.Fatalf("lazy %v: %d uses in the 10s after lowering the rate, want %d to %d", lazy, uses, low.BurstAmount, low.BurstAmount+10)
+		}
+	}
+}
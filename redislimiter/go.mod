@@ -0,0 +1,17 @@
+module github.com/joohnes/ratelimiter/redislimiter
+
+go 1.22.0
+
+require (
+	github.com/alicebob/miniredis/v2 v2.39.0
+	github.com/joohnes/ratelimiter v0.0.0-00010101000000-000000000000
+	github.com/redis/go-redis/v9 v9.7.3
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
+)
+
+replace github.com/joohnes/ratelimiter => ../
@@ -0,0 +1,224 @@
+// Package redislimiter provides a token bucket shared by several processes
+// through Redis, so that a rate can be enforced across every instance of a
+// service. It lives in its own module so that the core ratelimiter module
+// does not depend on a Redis client.
+package redislimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/joohnes/ratelimiter"
+)
+
+// script refills and consumes from the bucket stored at KEYS[1] atomically.
+// Time is taken from the Redis server so that every instance agrees on it.
+// ARGV holds the burst, the interval in milliseconds and the tokens to take;
+// taking zero only reports the state. It returns whether the tokens were
+// taken, the milliseconds until they could be, and the tokens available
+// including the progress towards the next one.
+var script = redis.NewScript(`
+redis.replicate_commands()
+
+local burst = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local requested = tonumber(ARGV[3])
+
+local time = redis.call("TIME")
+local now = time[1] * 1000 + math.floor(time[2] / 1000)
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+
+local accrued = math.floor((now - ts) / interval)
+if accrued > 0 then
+	tokens = math.min(burst, tokens + accrued)
+	ts = ts + accrued * interval
+end
+if tokens >= burst then
+	tokens = burst
+	ts = now
+end
+
+local allowed = 0
+local wait = 0
+if tokens >= requested then
+	tokens = tokens - requested
+	allowed = 1
+else
+	wait = ts + (requested - tokens) * interval - now
+end
+
+redis.call("HSET", KEYS[1], "tokens", tokens, "ts", ts)
+redis.call("PEXPIRE", KEYS[1], burst * interval * 2)
+
+local available = tokens
+if tokens < burst then
+	available = tokens + (now - ts) / interval
+end
+return {allowed, wait, tostring(available)}
+`)
+
+// Options holds the options for the RedisRateLimiter
+//
+// # Name is the Redis key of the bucket, limiters with the same name share it
+//
+// # BurstAmount is the amount of uses that can be used in a burst
+//
+// # Interval is the time to wait for the burst to refill by one
+//
+// # FailOpen allows every use while Redis is unreachable, instead of denying
+// them
+type Options struct {
+	Name        string
+	BurstAmount int
+	Interval    time.Duration
+	FailOpen    bool
+}
+
+// RedisRateLimiter is a token bucket kept in Redis. It implements
+// ratelimiter.Limiter.
+type RedisRateLimiter struct {
+	client redis.Scripter
+	opts   Options
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var _ ratelimiter.Limiter = (*RedisRateLimiter)(nil)
+
+func New(client redis.Scripter, opts Options) *RedisRateLimiter {
+	if opts.BurstAmount < 1 {
+		opts.BurstAmount = 1
+	}
+	if opts.Interval < time.Millisecond {
+		opts.Interval = time.Second
+	}
+
+	return &RedisRateLimiter{
+		client: client,
+		opts:   opts,
+		done:   make(chan struct{}),
+	}
+}
+
+// Use consumes a token if one is available. If Redis cannot be reached it
+// returns FailOpen.
+func (rl *RedisRateLimiter) Use() bool {
+	if rl.isClosed() {
+		return false
+	}
+
+	ok, _, _, err := rl.run(context.Background(), 1)
+	if err != nil {
+		return rl.opts.FailOpen
+	}
+	return ok
+}
+
+// Wait blocks until a token is consumed. It returns the context error, as
+// described for ratelimiter.RateLimiter.Wait, if ctx is done first and
+// ratelimiter.ErrClosed if the limiter is closed. If Redis cannot
+// be reached it returns nil when FailOpen is set and the error otherwise,
+// but a done ctx is reported as such even with FailOpen.
+func (rl *RedisRateLimiter) Wait(ctx context.Context) error {
+	for {
+		if rl.isClosed() {
+			return ratelimiter.ErrClosed
+		}
+
+		ok, wait, _, err := rl.run(ctx, 1)
+		if err != nil {
+			// A done ctx also fails the script, but that is the caller
+			// giving up, not Redis being unreachable.
+			if ctx.Err() != nil {
+				return ctxErr(ctx)
+			}
+			if rl.opts.FailOpen {
+				return nil
+			}
+			return err
+		}
+		if ok {
+			return nil
+		}
+
+		timer := time.NewTimer(max(wait, time.Millisecond))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctxErr(ctx)
+		case <-rl.done:
+			timer.Stop()
+			return ratelimiter.ErrClosed
+		}
+	}
+}
+
+// ctxErr returns the error of the done ctx, wrapped in
+// ratelimiter.ErrWaitTimeout if its deadline passed.
+func ctxErr(ctx context.Context) error {
+	if err := ctx.Err(); errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ratelimiter.ErrWaitTimeout, err)
+	}
+	return ctx.Err()
+}
+
+// Tokens returns the tokens available in the shared bucket, or 0 if Redis
+// cannot be reached.
+func (rl *RedisRateLimiter) Tokens() float64 {
+	_, _, tokens, err := rl.run(context.Background(), 0)
+	if err != nil {
+		return 0
+	}
+	return tokens
+}
+
+// Close makes every further Use fail and every Wait return
+// ratelimiter.ErrClosed. It does not close the Redis client, nor touch the
+// shared bucket. Calling Close more than once is safe.
+func (rl *RedisRateLimiter) Close() {
+	rl.closeOnce.Do(func() {
+		close(rl.done)
+	})
+}
+
+func (rl *RedisRateLimiter) isClosed() bool {
+	select {
+	case <-rl.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (rl *RedisRateLimiter) run(ctx context.Context, n int) (bool, time.Duration, float64, error) {
+	res, err := script.Run(ctx, rl.client, []string{rl.opts.Name},
+		rl.opts.BurstAmount, rl.opts.Interval.Milliseconds(), n).Slice()
+	if err != nil {
+		return false, 0, 0, err
+	}
+
+	allowed, _ := res[0].(int64)
+	wait, _ := res[1].(int64)
+	available, _ := res[2].(string)
+	tokens, err := strconv.ParseFloat(available, 64)
+	if err != nil {
+		return false, 0, 0, err
+	}
+
+	return allowed == 1, time.Duration(wait) * time.Millisecond, tokens, nil
+}
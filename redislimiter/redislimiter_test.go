@@ -0,0 +1,55 @@
+package redislimiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/joohnes/ratelimiter"
+)
+
+func TestSharedBucket(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	opts := Options{Name: "shared", BurstAmount: 3, Interval: time.Hour}
+	a, b := New(client, opts), New(client, opts)
+
+	if !a.Use() || !b.Use() || !a.Use() {
+		t.Fatal("uses within the shared burst were denied")
+	}
+	if b.Use() || a.Use() {
+		t.Fatal("a use beyond the shared burst was allowed")
+	}
+}
+
+func TestWaitFailOpenRespectsContext(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	rl := New(client, Options{Name: "ctx", BurstAmount: 1, Interval: time.Hour, FailOpen: true})
+	rl.Use()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := rl.Wait(ctx); !errors.Is(err, ratelimiter.ErrWaitTimeout) {
+		t.Fatalf("Wait on an empty bucket with FailOpen = %v, want ErrWaitTimeout", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := rl.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Wait with a cancelled ctx and FailOpen = %v, want context.Canceled", err)
+	}
+
+	mr.Close()
+	if err := rl.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait with Redis down and FailOpen = %v, want nil", err)
+	}
+}
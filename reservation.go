@@ -0,0 +1,123 @@
+package ratelimiter
+
+import "time"
+
+// Reservation is a token taken ahead of time by Reserve. The caller is
+// expected to wait for Delay before acting, or to Cancel the reservation if
+// it decides not to proceed.
+type Reservation struct {
+	rl        *RateLimiter
+	ok        bool
+	timeToAct time.Time
+	cancelled bool
+
+	// behind is set if the reservation queued behind waiters, see
+	// RateLimiter.behind.
+	behind bool
+
+	// cooldown is the burst cooldown the reservation started, and
+	// prevCooldown the one it replaced, to be restored by a Cancel as long
+	// as nothing has started a newer one.
+	cooldown     time.Time
+	prevCooldown time.Time
+}
+
+// Reserve takes a token now, even if it will only become available in the
+// future, and returns a Reservation describing when it can be used. Unlike
+// Wait it never blocks, so callers can schedule work without parking a
+// goroutine. If Wait calls are queued, the reservation queues behind them:
+// its token only comes due once they have all been served, and it neither
+// delays them nor their burst cooldown. The returned Reservation is not OK if
+// the limiter is paused or closed.
+func (rl *RateLimiter) Reserve() *Reservation {
+	rl.lock()
+	defer rl.unlock()
+
+	if rl.closed || rl.paused {
+		return &Reservation{rl: rl}
+	}
+
+	now := rl.clock.Now()
+	if rl.disabled {
+		return &Reservation{rl: rl, ok: true, timeToAct: now}
+	}
+	rl.refill(now)
+
+	if len(rl.queue) > 0 {
+		act := rl.due(rl.outstanding()+1, now)
+		if rl.burstCooldown.After(act) {
+			act = rl.burstCooldown
+		}
+
+		rl.burst -= 1
+		rl.behind += 1
+		rl.wakeTicker(now)
+		return &Reservation{rl: rl, ok: true, timeToAct: act, behind: true}
+	}
+
+	// Tokens already promised to earlier reservations have to refill
+	// before ours does.
+	act := rl.due(1, now)
+	if rl.burstCooldown.After(act) {
+		act = rl.burstCooldown
+	}
+
+	r := &Reservation{rl: rl, ok: true, timeToAct: act, prevCooldown: rl.burstCooldown}
+	rl.burst -= 1
+	rl.wakeTicker(now)
+	rl.burstCooldown = act.Add(rl.burstInterval)
+	r.cooldown = rl.burstCooldown
+	return r
+}
+
+// OK reports whether the reservation holds a token.
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Delay returns how long the caller has to wait before the reserved token
+// can be used. It is zero once the token is available.
+func (r *Reservation) Delay() time.Duration {
+	if !r.ok {
+		return 0
+	}
+
+	return max(r.timeToAct.Sub(r.rl.clock.Now()), 0)
+}
+
+// Cancel returns the reserved token to the bucket, and the burst cooldown to
+// where it was unless a later use or reservation has moved it since. It is a
+// no-op if the reservation is not OK, was already cancelled, or its time to
+// act has already passed, since the token is then considered used.
+func (r *Reservation) Cancel() {
+	r.cancel(false)
+}
+
+// cancel is Cancel, but with unused set it returns the token even once its
+// time to act has passed, for callers that know it was never used.
+func (r *Reservation) cancel(unused bool) {
+	if !r.ok {
+		return
+	}
+
+	rl := r.rl
+	rl.lock()
+	defer rl.unlock()
+
+	now := rl.clock.Now()
+	if r.cancelled || (!unused && !now.Before(r.timeToAct)) {
+		return
+	}
+
+	r.cancelled = true
+	rl.refill(now)
+	if r.behind && rl.behind >= 1 {
+		// Waiters queued before the reservation no longer get ahead of it,
+		// as its token is back in the bucket.
+		rl.behind -= 1
+	}
+	if !r.behind && rl.burstCooldown.Equal(r.cooldown) {
+		rl.burstCooldown = r.prevCooldown
+	}
+	rl.add(1)
+}
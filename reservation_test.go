@@ -0,0 +1,134 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReserveQueuesBehindWaiters(t *testing.T) {
+	for _, lazy := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		clk := newFakeClock()
+		opts := Options{BurstAmount: 2, Interval: 50 * time.Millisecond, BurstInterval: 10 * time.Millisecond}
+		var rl *RateLimiter
+		if lazy {
+			rl = newFakeLazyLimiter(opts, clk)
+		} else {
+			rl = newFakeLimiter(ctx, opts, clk)
+		}
+		rl.Drain()
+
+		// The waiter needs two tokens, due at 100ms, so a reservation made
+		// after it queued must come due after that.
+		done := startWaiters(t, ctx, rl, 2)
+		r := rl.Reserve()
+		if !r.OK() {
+			t.Fatal("Reserve not OK")
+		}
+		if got := r.Delay(); got != 150*time.Millisecond {
+			t.Errorf("lazy=%v: reservation delay = %v, want 150ms", lazy, got)
+		}
+
+		clk.Advance(100 * time.Millisecond)
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("lazy=%v: waiter held up by a later reservation", lazy)
+		}
+		if got := r.Delay(); got != 50*time.Millisecond {
+			t.Errorf("lazy=%v: reservation delay after the waiter = %v, want 50ms", lazy, got)
+		}
+		cancel()
+	}
+}
+
+func TestReserveCancelReturnsToken(t *testing.T) {
+	clk := newFakeClock()
+	rl := newFakeLazyLimiter(Options{BurstAmount: 1, Interval: time.Second}, clk)
+
+	r := rl.Reserve()
+	r2 := rl.Reserve()
+	if got := r2.Delay(); got != time.Second {
+		t.Fatalf("second reservation delay = %v, want 1s", got)
+	}
+	r2.Cancel()
+	if got := rl.Tokens(); got != 0 {
+		t.Fatalf("Tokens after cancelling the second reservation = %g, want 0", got)
+	}
+
+	// The first one is due right away, so it counts as used.
+	r.Cancel()
+	if rl.Use() {
+		t.Fatal("Cancel returned a token that was due")
+	}
+}
+
+func TestReserveCancelRestoresCooldown(t *testing.T) {
+	clk := newFakeClock()
+	rl := newFakeLazyLimiter(Options{BurstAmount: 5, Interval: time.Hour, BurstInterval: 50 * time.Millisecond}, clk)
+
+	for range 10 {
+		rl.Reserve().cancel(true)
+	}
+	if d := rl.TimeToNext(); d != 0 {
+		t.Fatalf("TimeToNext after cancelled reservations = %v, want 0", d)
+	}
+
+	// A cooldown started by a later use stays.
+	r := rl.Reserve()
+	clk.Advance(50 * time.Millisecond)
+	rl.Use()
+	r.cancel(true)
+	if d := rl.TimeToNext(); d != 50*time.Millisecond {
+		t.Fatalf("TimeToNext after a Use and a cancelled earlier reservation = %v, want 50ms", d)
+	}
+}
+
+func TestReserveLateCancel(t *testing.T) {
+	clk := newFakeClock()
+	rl := newFakeLazyLimiter(Options{BurstAmount: 1, Interval: time.Second}, clk)
+	rl.Drain()
+
+	r := rl.Reserve()
+	if got := r.Delay(); got != time.Second {
+		t.Fatalf("reservation delay = %v, want 1s", got)
+	}
+	clk.Advance(time.Second)
+	if got := r.Delay(); got != 0 {
+		t.Fatalf("reservation delay once due = %v, want 0", got)
+	}
+
+	// The token came due, so the caller is taken to have used it.
+	r.Cancel()
+	if got := rl.Tokens(); got != 0 {
+		t.Fatalf("Tokens after a late Cancel = %g, want 0", got)
+	}
+	if rl.Use() {
+		t.Fatal("late Cancel returned the token")
+	}
+}
+
+func TestReserveCancelAheadOfWaiter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	rl := newFakeLimiter(ctx, Options{BurstAmount: 1, Interval: time.Second}, clk)
+	rl.Drain()
+
+	// The waiter queues behind the reservation, for the token due at 2s.
+	r := rl.Reserve()
+	done := startWaiters(t, ctx, rl, 1)
+	r.Cancel()
+
+	// With the reservation's token back, the first refill is the waiter's.
+	clk.Advance(time.Second)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("waiter still blocked behind a cancelled reservation")
+	}
+}
@@ -0,0 +1,139 @@
+package ratelimiter
+
+import (
+	"context"
+	"time"
+)
+
+// day is the length of a day on the wall clock, ignoring DST changes.
+const day = 24 * time.Hour
+
+// ScheduleWindow applies Options during part of every day
+//
+// # Start is the time of day the window opens at, as an offset from midnight
+//
+// # End is the time of day the window closes at, up to 24h. A window with an
+// End before its Start wraps past midnight, and one with an End equal to its
+// Start is always closed
+//
+// # Options is the configuration in effect while the window is open
+type ScheduleWindow struct {
+	Start   time.Duration
+	End     time.Duration
+	Options Options
+}
+
+// contains reports whether the window is open at offset into the day.
+func (w ScheduleWindow) contains(offset time.Duration) bool {
+	if w.Start <= w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// Schedule holds the configurations of a ScheduledLimiter
+//
+// # Default is the configuration in effect while no window is open
+//
+// # Windows are the times of day that get a configuration of their own.
+// Where windows overlap, the first of them in the slice takes precedence
+//
+// # Location is the time zone the times of day are in, defaults to time.Local
+type Schedule struct {
+	Default  Options
+	Windows  []ScheduleWindow
+	Location *time.Location
+}
+
+// ScheduledLimiter is a RateLimiter whose configuration follows the time of
+// day, e.g. for an API that enforces lower limits during business hours. It
+// switches to the Options of a window as the wall clock enters it and back
+// to the default as it leaves, like SetOptions would, so the tokens left
+// carry over between configurations and Disabled and InitialBurst only
+// count in the configuration it starts with.
+//
+// It embeds the RateLimiter, so it is used and waited on like one, but any
+// reconfiguration made on it is replaced at the next window boundary.
+type ScheduledLimiter struct {
+	*RateLimiter
+
+	schedule Schedule
+}
+
+// NewScheduledLimiter returns a limiter configured for the current time of
+// day by schedule, refilled and switched between configurations until ctx is
+// done or it is closed.
+func NewScheduledLimiter(ctx context.Context, schedule Schedule) *ScheduledLimiter {
+	return newScheduledLimiter(ctx, schedule, realClock{})
+}
+
+func newScheduledLimiter(ctx context.Context, schedule Schedule, clk clock) *ScheduledLimiter {
+	if schedule.Location == nil {
+		schedule.Location = time.Local
+	}
+
+	sl := &ScheduledLimiter{schedule: schedule}
+	sl.RateLimiter = newRateLimiter(sl.optionsAt(clk.Now()), clk)
+	sl.start(ctx)
+	go sl.run(ctx)
+	return sl
+}
+
+// run applies the configuration of every window boundary as it is crossed.
+func (sl *ScheduledLimiter) run(ctx context.Context) {
+	for {
+		now := sl.clock.Now()
+		timer := sl.clock.NewTimer(sl.untilBoundary(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-sl.done:
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+
+		// Reconfiguring is a no-op if the boundary did not change the
+		// options, e.g. because an overlapping window takes precedence.
+		sl.setOptions(sl.optionsAt(sl.clock.Now()))
+	}
+}
+
+// optionsAt returns the configuration in effect at t.
+func (sl *ScheduledLimiter) optionsAt(t time.Time) Options {
+	offset := sl.offset(t)
+	for _, w := range sl.schedule.Windows {
+		if w.contains(offset) {
+			return w.Options
+		}
+	}
+	return sl.schedule.Default
+}
+
+// offset returns the time of day of t on the wall clock, as an offset from
+// midnight.
+func (sl *ScheduledLimiter) offset(t time.Time) time.Duration {
+	hour, minute, sec := t.In(sl.schedule.Location).Clock()
+	return time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute +
+		time.Duration(sec)*time.Second + time.Duration(t.Nanosecond())
+}
+
+// untilBoundary returns how long after now the next window opens or closes.
+// Without any windows it waits a day, only to find nothing changed.
+func (sl *ScheduledLimiter) untilBoundary(now time.Time) time.Duration {
+	y, m, d := now.In(sl.schedule.Location).Date()
+	next := day
+	for _, w := range sl.schedule.Windows {
+		for _, b := range []time.Duration{w.Start, w.End} {
+			// time.Date normalizes the time of day onto the wall clock, so
+			// boundaries stay put across DST changes.
+			at := time.Date(y, m, d, 0, 0, 0, int(b%day), sl.schedule.Location)
+			if !at.After(now) {
+				at = time.Date(y, m, d+1, 0, 0, 0, int(b%day), sl.schedule.Location)
+			}
+			next = min(next, at.Sub(now))
+		}
+	}
+	return next
+}
@@ -0,0 +1,100 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// scheduleStep advances clk to offset into its first day, once the limiter
+// is waiting for that boundary, and checks the MaxBurst configured there.
+func scheduleStep(t *testing.T, clk *fakeClock, sl *ScheduledLimiter, start time.Time, offset time.Duration, want int) {
+	t.Helper()
+
+	at := start.Add(offset)
+	awaitTimer(t, clk, at)
+	clk.Advance(at.Sub(clk.Now()))
+	eventually(t, func() bool { return sl.MaxBurst() == want })
+}
+
+// burstOpts returns Options with the given burst and no refill in between
+// the boundaries.
+func burstOpts(burst int) Options {
+	return Options{BurstAmount: burst, Interval: 24 * time.Hour}
+}
+
+func TestScheduledLimiterBoundaries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	start := clk.Now()
+	sl := newScheduledLimiter(ctx, Schedule{
+		Default:  burstOpts(10),
+		Windows:  []ScheduleWindow{{Start: 9 * time.Hour, End: 17 * time.Hour, Options: burstOpts(2)}},
+		Location: time.UTC,
+	}, clk)
+
+	if got := sl.MaxBurst(); got != 10 {
+		t.Fatalf("MaxBurst at midnight = %d, want the default 10", got)
+	}
+	scheduleStep(t, clk, sl, start, 9*time.Hour, 2)
+	scheduleStep(t, clk, sl, start, 17*time.Hour, 10)
+	scheduleStep(t, clk, sl, start, 33*time.Hour, 2)
+}
+
+func TestScheduledLimiterWrapsPastMidnight(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	start := clk.Now()
+	sl := newScheduledLimiter(ctx, Schedule{
+		Default:  burstOpts(10),
+		Windows:  []ScheduleWindow{{Start: 22 * time.Hour, End: 6 * time.Hour, Options: burstOpts(2)}},
+		Location: time.UTC,
+	}, clk)
+
+	if got := sl.MaxBurst(); got != 2 {
+		t.Fatalf("MaxBurst at midnight = %d, want 2 of the window open since 22:00", got)
+	}
+	scheduleStep(t, clk, sl, start, 6*time.Hour, 10)
+	scheduleStep(t, clk, sl, start, 22*time.Hour, 2)
+	scheduleStep(t, clk, sl, start, 30*time.Hour, 10)
+}
+
+func TestScheduledLimiterOverlap(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	start := clk.Now()
+	sl := newScheduledLimiter(ctx, Schedule{
+		Default: burstOpts(10),
+		// The lunch window comes first, so it wins over the business
+		// hours it overlaps.
+		Windows: []ScheduleWindow{
+			{Start: 12 * time.Hour, End: 13 * time.Hour, Options: burstOpts(5)},
+			{Start: 9 * time.Hour, End: 17 * time.Hour, Options: burstOpts(2)},
+		},
+		Location: time.UTC,
+	}, clk)
+
+	scheduleStep(t, clk, sl, start, 9*time.Hour, 2)
+	scheduleStep(t, clk, sl, start, 12*time.Hour, 5)
+	scheduleStep(t, clk, sl, start, 13*time.Hour, 2)
+	scheduleStep(t, clk, sl, start, 17*time.Hour, 10)
+
+	// The other way round, the business hours hide the lunch window.
+	reversed := &ScheduledLimiter{schedule: Schedule{
+		Default: burstOpts(10),
+		Windows: []ScheduleWindow{
+			{Start: 9 * time.Hour, End: 17 * time.Hour, Options: burstOpts(2)},
+			{Start: 12 * time.Hour, End: 13 * time.Hour, Options: burstOpts(5)},
+		},
+		Location: time.UTC,
+	}}
+	if got := reversed.optionsAt(start.Add(12*time.Hour + 30*time.Minute)).BurstAmount; got != 2 {
+		t.Fatalf("BurstAmount at 12:30 = %d, want 2 of the earlier window", got)
+	}
+}
@@ -0,0 +1,123 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SlidingWindowLimiter allows at most limit uses in any rolling window. The
+// times of the uses still inside the window are kept in a ring buffer that
+// never grows beyond limit entries.
+type SlidingWindowLimiter struct {
+	mu sync.Mutex
+
+	limit  int
+	window time.Duration
+
+	times []time.Time
+	head  int
+	count int
+
+	closed    bool
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewSlidingWindowLimiter(limit int, window time.Duration) *SlidingWindowLimiter {
+	if limit < 1 {
+		limit = 1
+	}
+	if window < 1 {
+		window = time.Second
+	}
+
+	return &SlidingWindowLimiter{
+		limit:  limit,
+		window: window,
+		times:  make([]time.Time, limit),
+		done:   make(chan struct{}),
+	}
+}
+
+func (sw *SlidingWindowLimiter) Use() bool {
+	ok, _, _ := sw.take()
+	return ok
+}
+
+// Wait blocks until a use is allowed. It returns the context error, see
+// RateLimiter.Wait, if ctx is done first, or ErrClosed if the limiter is
+// closed.
+func (sw *SlidingWindowLimiter) Wait(ctx context.Context) error {
+	for {
+		ok, wait, err := sw.take()
+		if ok {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctxErr(ctx)
+		case <-sw.done:
+			timer.Stop()
+			return ErrClosed
+		}
+	}
+}
+
+// Tokens returns how many more uses the current window has room for.
+func (sw *SlidingWindowLimiter) Tokens() float64 {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	sw.evict(time.Now())
+	return float64(sw.limit - sw.count)
+}
+
+// Close makes every further Use fail and every Wait return ErrClosed.
+// Calling Close more than once is safe.
+func (sw *SlidingWindowLimiter) Close() {
+	sw.closeOnce.Do(func() {
+		sw.mu.Lock()
+		defer sw.mu.Unlock()
+
+		sw.closed = true
+		close(sw.done)
+	})
+}
+
+// take records a use if the window has room for it. Otherwise it returns
+// how long until the oldest use leaves the window.
+func (sw *SlidingWindowLimiter) take() (bool, time.Duration, error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	if sw.closed {
+		return false, 0, ErrClosed
+	}
+
+	now := time.Now()
+	sw.evict(now)
+	if sw.count == sw.limit {
+		return false, sw.times[sw.head].Add(sw.window).Sub(now), nil
+	}
+
+	sw.times[(sw.head+sw.count)%sw.limit] = now
+	sw.count++
+	return true, 0, nil
+}
+
+// evict drops the uses that are no longer inside the window. The caller must
+// hold sw.mu.
+func (sw *SlidingWindowLimiter) evict(now time.Time) {
+	for sw.count > 0 && !now.Before(sw.times[sw.head].Add(sw.window)) {
+		sw.head = (sw.head + 1) % sw.limit
+		sw.count--
+	}
+}
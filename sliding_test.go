@@ -0,0 +1,38 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlidingWindowLimit(t *testing.T) {
+	const limit, window = 3, 50 * time.Millisecond
+	sw := NewSlidingWindowLimiter(limit, window)
+	defer sw.Close()
+
+	// The limiter reads the clock somewhere between before and after the
+	// Use that it allowed.
+	var before, after []time.Time
+	for end := time.Now().Add(4 * window); time.Now().Before(end); {
+		b := time.Now()
+		if sw.Use() {
+			before, after = append(before, b), append(after, time.Now())
+		}
+	}
+
+	if len(after) < 2*limit {
+		t.Fatalf("%d uses allowed over 4 windows, want at least %d", len(after), 2*limit)
+	}
+	// No window may hold more than limit uses, or limit+1 of them would fit
+	// in less than a window.
+	for i := limit; i < len(after); i++ {
+		if d := after[i].Sub(before[i-limit]); d < window {
+			t.Fatalf("uses %d and %d allowed %v apart, want at least %v", i-limit, i, d, window)
+		}
+	}
+	// Every window is full in turn, since the uses are attempted
+	// continuously.
+	if first := before[limit-1].Sub(after[0]); first >= window {
+		t.Fatalf("first %d uses spread over %v, want them within the first window", limit, first)
+	}
+}
@@ -0,0 +1,61 @@
+package ratelimiter
+
+import "time"
+
+// Stats is a point-in-time snapshot of a limiter's counters and state
+//
+// # Allowed is the number of successful Use and Wait calls
+//
+// # Denied is the number of Use calls that found no token available
+//
+// # WaitersBlocked is the number of Wait calls currently blocked
+//
+// # WaitCount is the number of Wait calls that had to block, and
+// TotalWaitDuration the time they spent blocked
+type Stats struct {
+	Allowed           uint64
+	Denied            uint64
+	CurrentBurst      int
+	MaxBurst          int
+	Interval          time.Duration
+	WaitersBlocked    int
+	WaitCount         uint64
+	TotalWaitDuration time.Duration
+}
+
+// Stats returns a snapshot of the limiter's counters and state. It is cheap
+// enough to be scraped frequently.
+func (rl *RateLimiter) Stats() Stats {
+	rl.lock()
+	defer rl.unlock()
+
+	rl.refill(rl.clock.Now())
+	return Stats{
+		Allowed:           rl.allowed.Load(),
+		Denied:            rl.denied.Load(),
+		CurrentBurst:      rl.whole(),
+		MaxBurst:          rl.maxBurst,
+		Interval:          rl.interval,
+		WaitersBlocked:    int(rl.waiters.Load()),
+		WaitCount:         rl.waitCount.Load(),
+		TotalWaitDuration: time.Duration(rl.waitTotal.Load()),
+	}
+}
+
+// ResetStats zeroes the Allowed, Denied, WaitCount and TotalWaitDuration
+// counters, e.g. at the start of a reporting period. The tokens and the
+// number of currently blocked waiters are left alone. It is safe to call
+// concurrently with everything else, but a call that completes while the
+// counters are being reset may or may not be counted.
+func (rl *RateLimiter) ResetStats() {
+	rl.allowed.Store(0)
+	rl.denied.Store(0)
+	rl.waitCount.Store(0)
+	rl.waitTotal.Store(0)
+}
+
+// recordWait adds the time blocked since start to the wait statistics.
+func (rl *RateLimiter) recordWait(start time.Time) {
+	rl.waitCount.Add(1)
+	rl.waitTotal.Add(int64(rl.clock.Now().Sub(start)))
+}
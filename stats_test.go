@@ -0,0 +1,94 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	rl := newFakeLimiter(ctx, Options{BurstAmount: 2, Interval: time.Second}, clk)
+	for range 3 {
+		rl.Use()
+	}
+	rl.UseN(3)
+
+	want := Stats{Allowed: 2, Denied: 2, CurrentBurst: 0, MaxBurst: 2, Interval: time.Second}
+	if got := rl.Stats(); got != want {
+		t.Fatalf("Stats = %+v, want %+v", got, want)
+	}
+
+	done := startWaiters(t, ctx, rl, 1)
+	if got := rl.Stats().WaitersBlocked; got != 1 {
+		t.Fatalf("WaitersBlocked with a Wait blocked = %d, want 1", got)
+	}
+	clk.Advance(time.Second)
+	<-done
+
+	want = Stats{Allowed: 3, Denied: 2, CurrentBurst: 0, MaxBurst: 2, Interval: time.Second, WaitCount: 1, TotalWaitDuration: time.Second}
+	if got := rl.Stats(); got != want {
+		t.Fatalf("Stats after the Wait = %+v, want %+v", got, want)
+	}
+}
+
+func TestWaitStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rl := NewRateLimiterWithBurst(ctx, Options{BurstAmount: 1, Interval: 20 * time.Millisecond})
+
+	// An immediate Wait does not block, so it is not counted.
+	rl.Wait(ctx)
+	if got := rl.Stats(); got.WaitCount != 0 || got.TotalWaitDuration != 0 {
+		t.Fatalf("wait stats after an immediate Wait = %d, %v, want 0, 0", got.WaitCount, got.TotalWaitDuration)
+	}
+
+	start := time.Now()
+	for range 3 {
+		if err := rl.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	elapsed := time.Since(start)
+
+	got := rl.Stats()
+	if got.WaitCount != 3 {
+		t.Fatalf("WaitCount after 3 blocking Waits = %d, want 3", got.WaitCount)
+	}
+	// Each Wait blocks until the next refill, so they block for about a
+	// refill each, and at most for as long as they took together.
+	if got.TotalWaitDuration < 40*time.Millisecond || got.TotalWaitDuration > elapsed {
+		t.Fatalf("TotalWaitDuration of 3 Waits = %v, want between 40ms and the %v they took", got.TotalWaitDuration, elapsed)
+	}
+}
+
+func TestResetStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	rl := newFakeLimiter(ctx, Options{BurstAmount: 3, Interval: time.Second}, clk)
+	rl.Drain()
+	done := startWaiters(t, ctx, rl, 1)
+	clk.Advance(time.Second)
+	<-done
+	rl.Use()
+	clk.Advance(2 * time.Second)
+	if got := rl.Stats(); got.Allowed == 0 || got.Denied == 0 || got.WaitCount == 0 || got.TotalWaitDuration == 0 {
+		t.Fatalf("Stats before ResetStats = %+v, want every counter set", got)
+	}
+	tokens := rl.Tokens()
+
+	rl.ResetStats()
+	want := Stats{CurrentBurst: 2, MaxBurst: 3, Interval: time.Second}
+	if got := rl.Stats(); got != want {
+		t.Fatalf("Stats after ResetStats = %+v, want %+v", got, want)
+	}
+	if got := rl.Tokens(); got != tokens {
+		t.Fatalf("Tokens after ResetStats = %v, want them untouched at %v", got, tokens)
+	}
+}